@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// backfillPageSize is how many lines are requested from a backfill source
+	// at a time.
+	backfillPageSize = 5000
+
+	// backfillRequestTimeout limits how long a single request to a backfill
+	// source may take.
+	backfillRequestTimeout = time.Minute
+
+	// backfillMinAge is how far back a stream must start for history to be
+	// fetched from the backfill source. More recent history is always still
+	// available from the kubelet.
+	backfillMinAge = time.Minute
+)
+
+// BackfillSource fetches the history of a container from a cluster logging
+// system, for lines that the kubelet no longer has because logs were rotated.
+type BackfillSource interface {
+	// Fetch calls fn for each line of the container logged in the interval
+	// [from, to), in order.
+	Fetch(
+		ctx context.Context,
+		pod *v1.Pod,
+		container *v1.Container,
+		from, to time.Time,
+		fn func(timestamp time.Time, message string)) error
+}
+
+// NewBackfillSource returns a backfill source of a kind ("loki" or
+// "elasticsearch") at a URL. For Elasticsearch, the URL's path is the index
+// pattern to search.
+func NewBackfillSource(kind, rawURL string) (BackfillSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("URL %q must be http or https", rawURL)
+	}
+	client := &http.Client{Timeout: backfillRequestTimeout}
+	switch kind {
+	case "loki":
+		return &lokiBackfill{url: u, client: client}, nil
+	case "elasticsearch":
+		if strings.Trim(u.Path, "/") == "" {
+			return nil, fmt.Errorf("URL %q must include the index to search, e.g. /logs-*", rawURL)
+		}
+		return &elasticsearchBackfill{url: u, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown backfill source %q", kind)
+}
+
+// lokiBackfill queries Loki for streams with the namespace, pod and container
+// labels, as set up by Promtail and Grafana Alloy for Kubernetes.
+type lokiBackfill struct {
+	url    *url.URL
+	client *http.Client
+}
+
+type lokiResponse struct {
+	Data struct {
+		Result []struct {
+			Values [][2]string `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (b *lokiBackfill) Fetch(
+	ctx context.Context,
+	pod *v1.Pod,
+	container *v1.Container,
+	from, to time.Time,
+	fn func(timestamp time.Time, message string)) error {
+	query := fmt.Sprintf(`{namespace=%q, pod=%q, container=%q}`,
+		pod.Namespace, pod.Name, container.Name)
+
+	type entry struct {
+		timestamp time.Time
+		message   string
+	}
+
+	// Pages overlap by the last timestamp, since several lines may share it;
+	// lines already seen at that timestamp are skipped
+	start := from
+	var seenAtStart map[string]int
+	var truncatedAt time.Time
+	for {
+		u := *b.url
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/loki/api/v1/query_range"
+		u.RawQuery = url.Values{
+			"query":     {query},
+			"start":     {strconv.FormatInt(start.UnixNano(), 10)},
+			"end":       {strconv.FormatInt(to.UnixNano(), 10)},
+			"limit":     {strconv.Itoa(backfillPageSize)},
+			"direction": {"forward"},
+		}.Encode()
+
+		var response lokiResponse
+		if err := getBackfillJSON(ctx, b.client, http.MethodGet, u.String(), nil, &response); err != nil {
+			return fmt.Errorf("querying Loki: %w", err)
+		}
+
+		var entries []entry
+		for _, result := range response.Data.Result {
+			for _, value := range result.Values {
+				ns, err := strconv.ParseInt(value[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("querying Loki: invalid timestamp %q", value[0])
+				}
+				entries = append(entries, entry{timestamp: time.Unix(0, ns), message: value[1]})
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].timestamp.Before(entries[j].timestamp)
+		})
+
+		var (
+			last       time.Time
+			seenAtLast map[string]int
+			emitted    int
+		)
+		for _, e := range entries {
+			if !e.timestamp.Equal(last) {
+				last, seenAtLast = e.timestamp, map[string]int{}
+			}
+			seenAtLast[e.message]++
+			if e.timestamp.Equal(start) && seenAtStart[e.message] > 0 {
+				seenAtStart[e.message]--
+				continue
+			}
+			fn(e.timestamp, strings.TrimSuffix(e.message, "\n"))
+			emitted++
+		}
+		if len(entries) < backfillPageSize {
+			break
+		}
+		if emitted == 0 {
+			// More lines share this timestamp than fit in a page, and Loki
+			// can't page within a timestamp, so the rest are skipped
+			if truncatedAt.IsZero() {
+				truncatedAt = start
+			}
+			start, seenAtStart = start.Add(time.Nanosecond), nil
+			continue
+		}
+		start, seenAtStart = last, seenAtLast
+	}
+	if !truncatedAt.IsZero() {
+		return fmt.Errorf("querying Loki: some lines were skipped, since more than %d share a"+
+			" timestamp (first at %s)", backfillPageSize, truncatedAt.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// elasticsearchBackfill searches an Elasticsearch or OpenSearch index for
+// documents with the Kubernetes metadata added by Fluent Bit and Fluentd.
+type elasticsearchBackfill struct {
+	url    *url.URL
+	client *http.Client
+}
+
+type elasticsearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source struct {
+				Timestamp string `json:"@timestamp"`
+				Log       string `json:"log"`
+				Message   string `json:"message"`
+			} `json:"_source"`
+			Sort []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (b *elasticsearchBackfill) Fetch(
+	ctx context.Context,
+	pod *v1.Pod,
+	container *v1.Container,
+	from, to time.Time,
+	fn func(timestamp time.Time, message string)) error {
+	u := *b.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/_search"
+
+	var searchAfter []interface{}
+	for {
+		search := map[string]interface{}{
+			"size": backfillPageSize,
+			"sort": []interface{}{
+				map[string]string{"@timestamp": "asc"},
+				map[string]string{"_doc": "asc"},
+			},
+			"query": map[string]interface{}{
+				"bool": map[string]interface{}{
+					"filter": []interface{}{
+						matchPhrase("kubernetes.namespace_name", pod.Namespace),
+						matchPhrase("kubernetes.pod_name", pod.Name),
+						matchPhrase("kubernetes.container_name", container.Name),
+						map[string]interface{}{
+							"range": map[string]interface{}{
+								"@timestamp": map[string]string{
+									"gte": from.UTC().Format(time.RFC3339Nano),
+									"lt":  to.UTC().Format(time.RFC3339Nano),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		if searchAfter != nil {
+			search["search_after"] = searchAfter
+		}
+		body, err := json.Marshal(search)
+		if err != nil {
+			return err
+		}
+
+		var response elasticsearchResponse
+		if err := getBackfillJSON(ctx, b.client, http.MethodPost, u.String(), body, &response); err != nil {
+			return fmt.Errorf("searching Elasticsearch: %w", err)
+		}
+
+		hits := response.Hits.Hits
+		for _, hit := range hits {
+			timestamp, err := time.Parse(time.RFC3339Nano, hit.Source.Timestamp)
+			if err != nil {
+				continue
+			}
+			message := hit.Source.Log
+			if message == "" {
+				message = hit.Source.Message
+			}
+			fn(timestamp, strings.TrimSuffix(message, "\n"))
+		}
+		if len(hits) < backfillPageSize {
+			return nil
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+func matchPhrase(field, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"match_phrase": map[string]string{field: value},
+	}
+}
+
+func getBackfillJSON(
+	ctx context.Context,
+	client *http.Client,
+	method, target string,
+	body []byte,
+	result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type backfillLine struct {
+	timestamp time.Time
+	message   string
+}
+
+var (
+	backfillPod = &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-1"},
+	}
+	backfillContainer = &v1.Container{Name: "app"}
+	backfillEpoch     = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+)
+
+// newLokiServer serves query_range requests from lines, which must be in
+// order, like Loki does for a forward query.
+func newLokiServer(t *testing.T, lines []backfillLine) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/loki/api/v1/query_range" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if expected := `{namespace="shop", pod="checkout-1", container="app"}`; query.Get("query") != expected {
+			t.Errorf("expected query %s, got %s", expected, query.Get("query"))
+		}
+		start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		var values [][2]string
+		for _, line := range lines {
+			if ns := line.timestamp.UnixNano(); ns >= start && ns < end && len(values) < limit {
+				values = append(values, [2]string{strconv.FormatInt(ns, 10), line.message + "\n"})
+			}
+		}
+		var response lokiResponse
+		response.Data.Result = append(response.Data.Result, struct {
+			Values [][2]string `json:"values"`
+		}{Values: values})
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func fetchBackfill(t *testing.T, source BackfillSource, from, to time.Time) ([]backfillLine, error) {
+	t.Helper()
+	var lines []backfillLine
+	err := source.Fetch(context.Background(), backfillPod, backfillContainer, from, to,
+		func(timestamp time.Time, message string) {
+			lines = append(lines, backfillLine{timestamp: timestamp, message: message})
+		})
+	return lines, err
+}
+
+func checkBackfillLines(t *testing.T, expected, actual []backfillLine) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if !actual[i].timestamp.Equal(expected[i].timestamp) || actual[i].message != expected[i].message {
+			t.Fatalf("line %d: expected %v, got %v", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestLokiBackfillPagesThroughSharedTimestamps(t *testing.T) {
+	// Two lines per timestamp, so that pages end in the middle of one
+	var lines []backfillLine
+	for i := 0; i < 2*backfillPageSize+501; i++ {
+		lines = append(lines, backfillLine{
+			timestamp: backfillEpoch.Add(time.Duration(i/2) * time.Millisecond),
+			message:   fmt.Sprintf("line %d", i),
+		})
+	}
+	server := newLokiServer(t, lines)
+	defer server.Close()
+
+	source, err := NewBackfillSource("loki", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fetchBackfill(t, source, backfillEpoch, backfillEpoch.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBackfillLines(t, lines, actual)
+}
+
+func TestLokiBackfillReportsLinesSkippedAtOneTimestamp(t *testing.T) {
+	var lines []backfillLine
+	for i := 0; i < backfillPageSize+10; i++ {
+		lines = append(lines, backfillLine{timestamp: backfillEpoch, message: fmt.Sprintf("line %d", i)})
+	}
+	later := []backfillLine{
+		{timestamp: backfillEpoch.Add(time.Second), message: "later 1"},
+		{timestamp: backfillEpoch.Add(2 * time.Second), message: "later 2"},
+	}
+	server := newLokiServer(t, append(lines, later...))
+	defer server.Close()
+
+	source, err := NewBackfillSource("loki", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fetchBackfill(t, source, backfillEpoch, backfillEpoch.Add(time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "skipped") {
+		t.Errorf("expected an error about skipped lines, got %v", err)
+	}
+	// History carries on after the timestamp that couldn't be paged through
+	checkBackfillLines(t, append(lines[:backfillPageSize:backfillPageSize], later...), actual)
+}
+
+type elasticsearchDoc struct {
+	timestamp time.Time
+	doc       int
+	field     string
+	message   string
+}
+
+// newElasticsearchServer serves searches sorted by timestamp and document,
+// paged with search_after.
+func newElasticsearchServer(t *testing.T, docs []elasticsearchDoc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/logs-*/_search" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var search struct {
+			Size  int `json:"size"`
+			Query struct {
+				Bool struct {
+					Filter []map[string]map[string]json.RawMessage `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+			SearchAfter []float64 `json:"search_after"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+			t.Fatal(err)
+		}
+		var gte, lt time.Time
+		matches := map[string]string{}
+		for _, filter := range search.Query.Bool.Filter {
+			if phrase, ok := filter["match_phrase"]; ok {
+				for field, value := range phrase {
+					matches[field] = strings.Trim(string(value), `"`)
+				}
+			}
+			if rng, ok := filter["range"]; ok {
+				var bounds struct {
+					Gte time.Time `json:"gte"`
+					Lt  time.Time `json:"lt"`
+				}
+				_ = json.Unmarshal(rng["@timestamp"], &bounds)
+				gte, lt = bounds.Gte, bounds.Lt
+			}
+		}
+		if matches["kubernetes.namespace_name"] != "shop" || matches["kubernetes.pod_name"] != "checkout-1" ||
+			matches["kubernetes.container_name"] != "app" {
+			t.Errorf("unexpected filters %v", matches)
+		}
+
+		type hit struct {
+			Source map[string]string `json:"_source"`
+			Sort   []float64         `json:"sort"`
+		}
+		var hits []hit
+		for _, doc := range docs {
+			if doc.timestamp.Before(gte) || !doc.timestamp.Before(lt) {
+				continue
+			}
+			sortKey := []float64{float64(doc.timestamp.UnixMilli()), float64(doc.doc)}
+			if search.SearchAfter != nil && (sortKey[0] < search.SearchAfter[0] ||
+				(sortKey[0] == search.SearchAfter[0] && sortKey[1] <= search.SearchAfter[1])) {
+				continue
+			}
+			hits = append(hits, hit{
+				Source: map[string]string{
+					"@timestamp": doc.timestamp.Format(time.RFC3339Nano),
+					doc.field:    doc.message,
+				},
+				Sort: sortKey,
+			})
+			if len(hits) == search.Size {
+				break
+			}
+		}
+		response := map[string]interface{}{"hits": map[string]interface{}{"hits": hits}}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestElasticsearchBackfillPagesWithSearchAfter(t *testing.T) {
+	var docs []elasticsearchDoc
+	var expected []backfillLine
+	for i := 0; i < 2*backfillPageSize+501; i++ {
+		doc := elasticsearchDoc{
+			timestamp: backfillEpoch.Add(time.Duration(i/3) * time.Millisecond),
+			doc:       i,
+			field:     "log",
+			message:   fmt.Sprintf("line %d", i),
+		}
+		if i%2 == 1 {
+			// Fluentd puts the line in "message" rather than "log"
+			doc.field = "message"
+		}
+		docs = append(docs, doc)
+		expected = append(expected, backfillLine{timestamp: doc.timestamp, message: doc.message})
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].timestamp.Before(docs[j].timestamp) })
+	server := newElasticsearchServer(t, docs)
+	defer server.Close()
+
+	source, err := NewBackfillSource("elasticsearch", server.URL+"/logs-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fetchBackfill(t, source, backfillEpoch, backfillEpoch.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBackfillLines(t, expected, actual)
+}
+
+func TestBackfillSourcesRespectInterval(t *testing.T) {
+	lines := []backfillLine{
+		{timestamp: backfillEpoch, message: "before"},
+		{timestamp: backfillEpoch.Add(time.Second), message: "inside"},
+		{timestamp: backfillEpoch.Add(2 * time.Second), message: "at the end"},
+	}
+	var docs []elasticsearchDoc
+	for i, line := range lines {
+		docs = append(docs, elasticsearchDoc{timestamp: line.timestamp, doc: i, field: "log", message: line.message})
+	}
+	loki := newLokiServer(t, lines)
+	defer loki.Close()
+	elasticsearch := newElasticsearchServer(t, docs)
+	defer elasticsearch.Close()
+
+	for kind, url := range map[string]string{
+		"loki":          loki.URL,
+		"elasticsearch": elasticsearch.URL + "/logs-*",
+	} {
+		t.Run(kind, func(t *testing.T) {
+			source, err := NewBackfillSource(kind, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := fetchBackfill(t, source, lines[1].timestamp, lines[2].timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBackfillLines(t, lines[1:2], actual)
+		})
+	}
+}
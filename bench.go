@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+const benchNamespace = "bench"
+
+// runBench runs the controller against a fake clientset that serves synthetic
+// pods and logs, and reports throughput and allocations.
+func runBench(args []string) {
+	var (
+		podCount       int
+		containerCount int
+		lineCount      int
+		lineSize       int
+		outputFormat   string
+	)
+
+	flags := pflag.NewFlagSet("ktail --bench", pflag.ContinueOnError)
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Printf("Usage: ktail --bench [OPTION ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.IntVar(&podCount, "pods", 10, "Number of synthetic pods")
+	flags.IntVar(&containerCount, "containers", 2, "Number of containers per pod")
+	flags.IntVar(&lineCount, "lines", 100000, "Number of lines to log per container")
+	flags.IntVar(&lineSize, "line-size", 200, "Size of each log message in bytes")
+	flags.StringVarP(&outputFormat, "output", "o", "",
+		"Also format events as 'json', 'logfmt', 'csv', 'tsv', or 'proto', writing to nowhere.")
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			os.Exit(2)
+		}
+		fail(err.Error())
+	}
+
+	printEvent := func(*LogEvent) error { return nil }
+	switch outputFormat {
+	case "":
+	case "json":
+		printEvent = newJSONPrinter(io.Discard, podMetadata{})
+	case "logfmt":
+		printEvent = newLogfmtPrinter(io.Discard, podMetadata{})
+	case "proto":
+		printEvent = newProtoPrinter(io.Discard, podMetadata{})
+	case "csv", "tsv":
+		separator := ','
+		if outputFormat == "tsv" {
+			separator = '\t'
+		}
+		var err error
+		printEvent, err = newCSVPrinter(io.Discard, nil, separator, true)
+		if err != nil {
+			fail(err.Error())
+		}
+	default:
+		fail("invalid --output flag: unknown output format %q", outputFormat)
+	}
+
+	done := make(chan struct{})
+	client := newBenchClientset(lineCount, lineSize, done)
+	if err := client.Tracker().Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: benchNamespace},
+	}); err != nil {
+		fail(err.Error())
+	}
+	for i := 0; i < podCount; i++ {
+		if err := client.Tracker().Add(newBenchPod(i, containerCount)); err != nil {
+			fail(err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	expected := int64(podCount * containerCount * lineCount)
+	var lines, bytes atomic.Int64
+	var mutex sync.Mutex
+	controller := NewController(client,
+		ControllerOptions{
+			Namespaces:       []string{benchNamespace},
+			InclusionMatcher: trueMatcher{},
+			ExclusionMatcher: falseMatcher{},
+			SinceStart:       true,
+		},
+		Callbacks{
+			OnEvent: func(event LogEvent) {
+				mutex.Lock()
+				err := printEvent(&event)
+				mutex.Unlock()
+				if err != nil {
+					fail(err.Error())
+				}
+				bytes.Add(int64(len(event.Message)))
+				if lines.Add(1) == expected {
+					cancel()
+				}
+			},
+			OnEnter: func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool {
+				return true
+			},
+			OnExit:              func(pod *v1.Pod, container *v1.Container) {},
+			OnNothingDiscovered: func() {},
+			OnError: func(pod *v1.Pod, container *v1.Container, err error) {
+				printError(fmt.Sprintf("Error while tailing container [%s/%s]: %s",
+					pod.Name, container.Name, err))
+			},
+		})
+
+	printInfo("Tailing %d pods with %d containers, %d lines of %d bytes each",
+		podCount, containerCount, lineCount, lineSize)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	_ = controller.Run(ctx)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	close(done)
+
+	n := lines.Load()
+	fmt.Printf("lines:          %d\n", n)
+	fmt.Printf("elapsed:        %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("lines/sec:      %.0f\n", float64(n)/elapsed.Seconds())
+	fmt.Printf("MiB/sec:        %.1f\n", float64(bytes.Load())/elapsed.Seconds()/(1024*1024))
+	if n > 0 {
+		fmt.Printf("allocs/line:    %.1f\n", float64(after.Mallocs-before.Mallocs)/float64(n))
+		fmt.Printf("bytes/line:     %.0f\n", float64(after.TotalAlloc-before.TotalAlloc)/float64(n))
+	}
+	fmt.Printf("GC cycles:      %d\n", after.NumGC-before.NumGC)
+}
+
+func newBenchPod(i, containerCount int) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: benchNamespace,
+			Name:      fmt.Sprintf("bench-%d", i),
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+		},
+	}
+	for j := 0; j < containerCount; j++ {
+		name := fmt.Sprintf("container-%d", j)
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: name})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+			Name: name,
+			State: v1.ContainerState{
+				Running: &v1.ContainerStateRunning{StartedAt: metav1.Now()},
+			},
+		})
+	}
+	return pod
+}
+
+// benchClientset is a fake clientset whose pod logs are synthetic. Each
+// container logs a fixed number of lines once; later requests block until
+// the benchmark is done, like a follow request for a container that has
+// stopped logging.
+type benchClientset struct {
+	*fake.Clientset
+	lineCount int
+	lineSize  int
+	done      chan struct{}
+	served    sync.Map
+}
+
+var _ kubernetes.Interface = &benchClientset{}
+
+func newBenchClientset(lineCount, lineSize int, done chan struct{}) *benchClientset {
+	clientset := fake.NewSimpleClientset()
+	return &benchClientset{
+		Clientset: clientset,
+		lineCount: lineCount,
+		lineSize:  lineSize,
+		done:      done,
+	}
+}
+
+func (c *benchClientset) CoreV1() corev1.CoreV1Interface {
+	return &benchCoreV1{CoreV1Interface: c.Clientset.CoreV1(), clientset: c}
+}
+
+type benchCoreV1 struct {
+	corev1.CoreV1Interface
+	clientset *benchClientset
+}
+
+func (c *benchCoreV1) Pods(namespace string) corev1.PodInterface {
+	return &benchPods{PodInterface: c.CoreV1Interface.Pods(namespace), clientset: c.clientset, namespace: namespace}
+}
+
+type benchPods struct {
+	corev1.PodInterface
+	clientset *benchClientset
+	namespace string
+}
+
+func (p *benchPods) GetLogs(name string, opts *v1.PodLogOptions) *rest.Request {
+	key := fmt.Sprintf("%s/%s/%s", p.namespace, name, opts.Container)
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+			var body io.ReadCloser
+			if _, served := p.clientset.served.LoadOrStore(key, true); served {
+				body = &blockingReader{done: p.clientset.done}
+			} else {
+				body = io.NopCloser(newSyntheticLogReader(p.clientset.lineCount, p.clientset.lineSize))
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         v1.SchemeGroupVersion,
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", p.namespace, name),
+	}
+	return client.Request()
+}
+
+// syntheticLogReader produces log lines in the format returned by the
+// Kubernetes API when timestamps are requested.
+type syntheticLogReader struct {
+	remaining int
+	message   string
+	buf       []byte
+}
+
+func newSyntheticLogReader(lineCount, lineSize int) *syntheticLogReader {
+	return &syntheticLogReader{
+		remaining: lineCount,
+		message:   strings.Repeat("x", lineSize),
+	}
+}
+
+func (r *syntheticLogReader) Read(p []byte) (int, error) {
+	for len(r.buf) < len(p) && r.remaining > 0 {
+		r.buf = time.Now().UTC().AppendFormat(r.buf, time.RFC3339Nano)
+		r.buf = append(r.buf, ' ')
+		r.buf = append(r.buf, r.message...)
+		r.buf = append(r.buf, '\n')
+		r.remaining--
+	}
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[:copy(r.buf, r.buf[n:])]
+	return n, nil
+}
+
+type blockingReader struct {
+	done chan struct{}
+}
+
+func (r *blockingReader) Read([]byte) (int, error) {
+	<-r.done
+	return 0, io.EOF
+}
+
+func (r *blockingReader) Close() error {
+	return nil
+}
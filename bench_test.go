@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyntheticLogReader(t *testing.T) {
+	scanner := bufio.NewScanner(newSyntheticLogReader(3, 10))
+	lines := 0
+	for scanner.Scan() {
+		timestamp, message, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			t.Fatalf("line %q has no timestamp", scanner.Text())
+		}
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			t.Errorf("invalid timestamp: %s", err)
+		}
+		if message != strings.Repeat("x", 10) {
+			t.Errorf("unexpected message %q", message)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("expected 3 lines, got %d", lines)
+	}
+}
+
+func TestBenchClientsetServesEveryLine(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	client := newBenchClientset(50, 20, done)
+	if err := client.Tracker().Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: benchNamespace},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := client.Tracker().Add(newBenchPod(i, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const expected = 3 * 2 * 50
+	var lines atomic.Int64
+	controller := NewController(client,
+		ControllerOptions{
+			Namespaces:       []string{benchNamespace},
+			InclusionMatcher: trueMatcher{},
+			ExclusionMatcher: falseMatcher{},
+			SinceStart:       true,
+		},
+		Callbacks{
+			OnEvent: func(event LogEvent) {
+				if lines.Add(1) == expected {
+					cancel()
+				}
+			},
+			OnEnter: func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool {
+				return true
+			},
+			OnExit:              func(pod *v1.Pod, container *v1.Container) {},
+			OnNothingDiscovered: func() {},
+			OnError: func(pod *v1.Pod, container *v1.Container, err error) {
+				t.Errorf("tailing %s/%s: %s", pod.Name, container.Name, err)
+			},
+		})
+	_ = controller.Run(ctx)
+
+	if n := lines.Load(); n != expected {
+		t.Errorf("expected %d lines, got %d", expected, n)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// eventOverhead is a rough estimate of the memory used by a buffered event,
+// not counting its message.
+const eventOverhead = 128
+
+// EventBuffer decouples tailers from a slow consumer. Events are kept in
+// memory up to a limit, after which they are spilled to a temporary file on
+// disk, and read back in order once the consumer catches up.
+type EventBuffer struct {
+	maxBytes int64
+	dir      string
+	onSpill  func(path string)
+
+	memory      []LogEvent
+	memoryBytes int64
+
+	spillFile    *os.File
+	spillReader  *os.File
+	spillWriter  *bufio.Writer
+	spillEncoder *gob.Encoder
+	spillDecoder *gob.Decoder
+	spilled      int
+	streams      map[string]*spilledStream
+
+	closed bool
+	cond   *sync.Cond
+	sync.Mutex
+}
+
+// spilledEvent is the on-disk representation of a LogEvent.
+type spilledEvent struct {
+	Stream     string
+	Timestamp  time.Time
+	Message    string
+	LineNumber int64
+	MatchLabel string
+	Parser     string
+	Marker     bool
+}
+
+// spilledStream holds the pod and container of events that are on disk.
+type spilledStream struct {
+	pod       *v1.Pod
+	container *v1.Container
+	refs      int
+}
+
+func NewEventBuffer(maxBytes int64, dir string, onSpill func(path string)) *EventBuffer {
+	b := &EventBuffer{
+		maxBytes: maxBytes,
+		dir:      dir,
+		onSpill:  onSpill,
+		streams:  map[string]*spilledStream{},
+	}
+	b.cond = sync.NewCond(&b.Mutex)
+	return b
+}
+
+// Push adds an event to the buffer. It never blocks on the consumer.
+func (b *EventBuffer) Push(event LogEvent) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	defer b.cond.Signal()
+
+	size := int64(len(event.Message)) + eventOverhead
+
+	// Once spilling has started, everything goes to disk until the consumer
+	// has caught up, so that events stay in order.
+	if b.spilled > 0 || b.memoryBytes+size > b.maxBytes {
+		err := b.spill(event)
+		if err == nil {
+			return nil
+		}
+		if b.spilled > 0 {
+			return err
+		}
+		// Couldn't start spilling; keep the event in memory rather than lose it
+		b.memory = append(b.memory, event)
+		b.memoryBytes += size
+		return err
+	}
+
+	b.memory = append(b.memory, event)
+	b.memoryBytes += size
+	return nil
+}
+
+// Run delivers buffered events to the consumer until the context is
+// cancelled. Events pushed after that are dropped, but those already in the
+// buffer are still delivered, in order, before Run returns.
+func (b *EventBuffer) Run(ctx context.Context, consume LogEventFunc) error {
+	go func() {
+		<-ctx.Done()
+		b.Lock()
+		b.closed = true
+		b.cond.Broadcast()
+		b.Unlock()
+	}()
+
+	for {
+		event, err := b.pop()
+		if err != nil {
+			return err
+		}
+		if event == nil {
+			return ctx.Err()
+		}
+		consume(*event)
+	}
+}
+
+func (b *EventBuffer) pop() (*LogEvent, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	for len(b.memory) == 0 && b.spilled == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.memory) == 0 && b.spilled == 0 {
+		// Closed, and drained
+		b.removeSpillFile()
+		return nil, nil
+	}
+
+	if len(b.memory) > 0 {
+		event := b.memory[0]
+		b.memory[0] = LogEvent{}
+		b.memory = b.memory[1:]
+		b.memoryBytes -= int64(len(event.Message)) + eventOverhead
+		return &event, nil
+	}
+	event, err := b.unspill()
+	if err != nil {
+		err = fmt.Errorf("%w (%d buffered events were lost)", err, b.spilled)
+		b.spilled = 0
+		b.streams = map[string]*spilledStream{}
+		b.removeSpillFile()
+	}
+	return event, err
+}
+
+func (b *EventBuffer) spill(event LogEvent) error {
+	if b.spillFile == nil {
+		f, err := os.CreateTemp(b.dir, "ktail-buffer-*")
+		if err != nil {
+			return fmt.Errorf("creating buffer file: %w", err)
+		}
+		b.spillFile = f
+		b.spillWriter = bufio.NewWriter(f)
+		b.spillEncoder = gob.NewEncoder(b.spillWriter)
+		b.spillDecoder = nil
+		if b.onSpill != nil {
+			b.onSpill(f.Name())
+		}
+	}
+
+	// Markers have no stream
+	var key string
+	var stream *spilledStream
+	if !event.Marker {
+		key = buildKey(event.Pod, event.Container)
+		var ok bool
+		if stream, ok = b.streams[key]; !ok {
+			stream = &spilledStream{pod: event.Pod, container: event.Container}
+			b.streams[key] = stream
+		}
+	}
+
+	if err := b.spillEncoder.Encode(&spilledEvent{
+		Stream:     key,
+		Timestamp:  *event.Timestamp,
+		Message:    event.Message,
+		LineNumber: event.LineNumber,
+		MatchLabel: event.MatchLabel,
+		Parser:     event.Parser,
+		Marker:     event.Marker,
+	}); err != nil {
+		return fmt.Errorf("writing to buffer file: %w", err)
+	}
+	if stream != nil {
+		stream.refs++
+	}
+	b.spilled++
+	return nil
+}
+
+func (b *EventBuffer) unspill() (*LogEvent, error) {
+	// All pending writes must be on disk before the decoder can read them
+	if err := b.spillWriter.Flush(); err != nil {
+		return nil, fmt.Errorf("writing to buffer file: %w", err)
+	}
+	if b.spillDecoder == nil {
+		reader, err := os.Open(b.spillFile.Name())
+		if err != nil {
+			return nil, fmt.Errorf("reading buffer file: %w", err)
+		}
+		b.spillReader = reader
+		b.spillDecoder = gob.NewDecoder(reader)
+	}
+
+	var spilled spilledEvent
+	if err := b.spillDecoder.Decode(&spilled); err != nil {
+		return nil, fmt.Errorf("reading buffer file: %w", err)
+	}
+	b.spilled--
+
+	if b.spilled == 0 {
+		// Caught up; go back to buffering in memory
+		b.removeSpillFile()
+	}
+
+	if spilled.Marker {
+		return &LogEvent{Timestamp: &spilled.Timestamp, Message: spilled.Message, Marker: true}, nil
+	}
+
+	stream := b.streams[spilled.Stream]
+	stream.refs--
+	if stream.refs == 0 {
+		delete(b.streams, spilled.Stream)
+	}
+
+	return &LogEvent{
+		Pod:        stream.pod,
+		Container:  stream.container,
+		Timestamp:  &spilled.Timestamp,
+		Message:    spilled.Message,
+		LineNumber: spilled.LineNumber,
+		MatchLabel: spilled.MatchLabel,
+		Parser:     spilled.Parser,
+	}, nil
+}
+
+func (b *EventBuffer) removeSpillFile() {
+	if b.spillFile == nil {
+		return
+	}
+	if b.spillReader != nil {
+		_ = b.spillReader.Close()
+	}
+	_ = b.spillFile.Close()
+	_ = os.Remove(b.spillFile.Name())
+	b.spillFile, b.spillReader = nil, nil
+	b.spillWriter, b.spillEncoder, b.spillDecoder = nil, nil, nil
+}
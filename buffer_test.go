@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBufferEvents(count int) []LogEvent {
+	timestamp := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "a", UID: "1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "b", UID: "2"}},
+	}
+	container := &v1.Container{Name: "app"}
+	var events []LogEvent
+	for i := 0; i < count; i++ {
+		ts := timestamp.Add(time.Duration(i) * time.Millisecond)
+		events = append(events, LogEvent{
+			Pod:        pods[i%len(pods)],
+			Container:  container,
+			Timestamp:  &ts,
+			Message:    fmt.Sprintf("line %d", i),
+			LineNumber: int64(i + 1),
+			MatchLabel: "label",
+			Parser:     "json",
+		})
+	}
+	return events
+}
+
+func checkBufferedEvents(t *testing.T, expected, actual []LogEvent) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		if a.Message != e.Message || !a.Timestamp.Equal(*e.Timestamp) || a.LineNumber != e.LineNumber ||
+			a.MatchLabel != e.MatchLabel || a.Parser != e.Parser ||
+			a.Pod.Name != e.Pod.Name || a.Container.Name != e.Container.Name {
+			t.Fatalf("event %d: expected %+v, got %+v", i, e, a)
+		}
+	}
+}
+
+func TestEventBufferSpillsAndUnspillsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	spilled := 0
+	// Room for a few events in memory, so that most go to disk
+	buffer := NewEventBuffer(4*(eventOverhead+10), dir, func(path string) { spilled++ })
+
+	events := newBufferEvents(100)
+	for _, event := range events[:50] {
+		if err := buffer.Push(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan LogEvent)
+	done := make(chan error)
+	go func() {
+		done <- buffer.Run(ctx, func(event LogEvent) { received <- event })
+	}()
+
+	var actual []LogEvent
+	for len(actual) < 25 {
+		actual = append(actual, <-received)
+	}
+	// Pushed while spilled events are still being read back
+	for _, event := range events[50:] {
+		if err := buffer.Push(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for len(actual) < len(events) {
+		actual = append(actual, <-received)
+	}
+	checkBufferedEvents(t, events, actual)
+
+	if spilled == 0 {
+		t.Error("expected events to be spilled to disk")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file to be removed, found %d files", len(entries))
+	}
+}
+
+func TestEventBufferDrainsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	buffer := NewEventBuffer(4*(eventOverhead+10), dir, nil)
+	events := newBufferEvents(50)
+	for _, event := range events {
+		if err := buffer.Push(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var actual []LogEvent
+	if err := buffer.Run(ctx, func(event LogEvent) { actual = append(actual, event) }); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	checkBufferedEvents(t, events, actual)
+
+	// Once closed, the buffer takes no more events
+	if err := buffer.Push(events[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(buffer.memory) != 0 || buffer.spilled != 0 {
+		t.Error("expected events pushed after shutdown to be dropped")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file to be removed, found %d files", len(entries))
+	}
+}
+
+func TestEventBufferKeepsMarkersInOrder(t *testing.T) {
+	buffer := NewEventBuffer(4*(eventOverhead+10), t.TempDir(), nil)
+	events := newBufferEvents(20)
+	for i, event := range events {
+		if i == 2 || i == 15 {
+			// One marker in memory, and one on disk
+			now := time.Now()
+			if err := buffer.Push(LogEvent{Timestamp: &now, Message: fmt.Sprintf("mark %d", i), Marker: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := buffer.Push(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var actual []string
+	_ = buffer.Run(ctx, func(event LogEvent) {
+		if event.Marker {
+			if event.Pod != nil || event.Container != nil {
+				t.Error("expected a marker to have no pod or container")
+			}
+			actual = append(actual, event.Message)
+		} else if event.LineNumber == 3 || event.LineNumber == 16 {
+			actual = append(actual, event.Message)
+		}
+	})
+	expected := "mark 2,line 2,mark 15,line 15"
+	if strings.Join(actual, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(actual, ","))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxConcurrentCaptures limits how many containers are read at once when
+// capturing a time window.
+const maxConcurrentCaptures = 10
+
+// Capture emits the lines that matching containers logged between from and
+// to, in order, and returns. Unlike Run, it doesn't follow the logs, and also
+// reads the previous run of containers that have restarted.
+func (ctl *Controller) Capture(ctx context.Context, from, to time.Time) error {
+	type target struct {
+		pod       *v1.Pod
+		container *v1.Container
+	}
+	var targets []target
+	for _, ns := range ctl.Namespaces {
+		for _, fieldSelector := range ctl.fieldSelectors() {
+			podList, err := ctl.getClient().CoreV1().Pods(ns).List(ctx, metav1.ListOptions{
+				FieldSelector: fieldSelector.String(),
+			})
+			if err != nil {
+				return fmt.Errorf("listing pods in %q: %w", ns, err)
+			}
+			for i := range podList.Items {
+				pod := &podList.Items[i]
+				for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+					for j := range containers {
+						if ctl.matches(pod, &containers[j]) {
+							targets = append(targets, target{pod: pod, container: &containers[j]})
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(targets) == 0 {
+		ctl.callbacks.OnNothingDiscovered()
+		return nil
+	}
+
+	var (
+		events []LogEvent
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, maxConcurrentCaptures)
+	for _, t := range targets {
+		if !ctl.callbacks.OnEnter(t.pod, t.container, true) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			captured, err := ctl.captureContainer(ctx, t.pod, t.container, from, to)
+			if err != nil {
+				ctl.callbacks.OnError(t.pod, t.container, err)
+			}
+			lock.Lock()
+			events = append(events, captured...)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Each container's lines are already in order, so a stable sort keeps
+	// lines with the same timestamp in their original order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(*events[j].Timestamp)
+	})
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ctl.callbacks.OnEvent(event)
+	}
+	return nil
+}
+
+// captureContainer returns the lines that a container logged between from
+// and to, from the backfill source if there is one, and then from the
+// previous and current runs of the container.
+func (ctl *Controller) captureContainer(
+	ctx context.Context,
+	pod *v1.Pod,
+	container *v1.Container,
+	from, to time.Time) ([]LogEvent, error) {
+	label := matchLabel(ctl.MatchLabels, pod)
+
+	var events []LogEvent
+	var cutoff *time.Time
+	tailer := NewContainerTailer(ctl.getClient(), *pod, *container, func(event LogEvent) {
+		// Lines from the kubelet that were already backfilled are skipped
+		if event.Timestamp.Before(from) || !event.Timestamp.Before(to) ||
+			(cutoff != nil && !event.Timestamp.After(*cutoff)) {
+			return
+		}
+		event.MatchLabel = label
+		events = append(events, event)
+	}, &from, ctl.TailerOverrides.apply(pod, container, ctl.Tailer))
+
+	restarted := containerRestartCount(pod, container) > 0
+
+	var backfillErr error
+	if ctl.Tailer.Backfill != nil {
+		// Anything from the kubelet's earliest line on is read from the
+		// kubelet
+		backfillTo := to
+		for _, previous := range []bool{true, false} {
+			if previous && !restarted {
+				continue
+			}
+			if first, ok := tailer.firstLogTimestamp(ctx, previous); ok && first.Before(backfillTo) {
+				backfillTo = first
+			}
+		}
+		if backfillTo.After(from) {
+			err := ctl.Tailer.Backfill.Fetch(ctx, pod, container, from, backfillTo, tailer.emitLine)
+			if err != nil {
+				// What the kubelet has is still worth reading
+				backfillErr = fmt.Errorf("backfilling history: %w", err)
+			}
+			if len(events) > 0 {
+				cutoff = events[len(events)-1].Timestamp
+			}
+		}
+	}
+
+	if restarted {
+		// The kubelet only keeps the previous run's logs until the next
+		// restart, so they may well be gone
+		_ = tailer.fetchWindow(ctx, from, to, true)
+	}
+	if err := tailer.fetchWindow(ctx, from, to, false); err != nil {
+		return events, errors.Join(backfillErr, err)
+	}
+	return events, backfillErr
+}
@@ -1,47 +1,237 @@
 package main
 
 import (
+	"fmt"
 	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
 
 	"github.com/fatih/color"
+	v1 "k8s.io/api/core/v1"
 )
 
+// colorKeyFunc returns the values that determine the color of a container's
+// lines.
+type colorKeyFunc func(pod *v1.Pod, container *v1.Container) []string
+
 type colorConfig struct {
 	labels   *color.Color
 	metadata *color.Color
 }
 
-var colorConfigs = []colorConfig{
-	{
-		color.New(color.FgHiBlue).Add(color.Bold),
-		color.New(color.FgBlue).Add(color.Bold),
-	},
-	{
-		color.New(color.FgHiCyan).Add(color.Bold),
-		color.New(color.FgCyan).Add(color.Bold),
-	},
-	{
-		color.New(color.FgHiGreen).Add(color.Bold),
-		color.New(color.FgGreen).Add(color.Bold),
-	},
-	{
-		color.New(color.FgHiMagenta).Add(color.Bold),
-		color.New(color.FgMagenta).Add(color.Bold),
-	},
-	{
-		color.New(color.FgHiRed).Add(color.Bold),
-		color.New(color.FgRed).Add(color.Bold),
-	},
-	{
-		color.New(color.FgHiYellow).Add(color.Bold),
-		color.New(color.FgYellow).Add(color.Bold),
+// colorPalette is a set of colors to assign to streams. If hueOrdered is true,
+// adjacent colors look similar, and are avoided for concurrently visible
+// streams.
+type colorPalette struct {
+	configs    []colorConfig
+	hueOrdered bool
+}
+
+var basicPalette = colorPalette{
+	configs: []colorConfig{
+		{
+			color.New(color.FgHiBlue).Add(color.Bold),
+			color.New(color.FgBlue).Add(color.Bold),
+		},
+		{
+			color.New(color.FgHiCyan).Add(color.Bold),
+			color.New(color.FgCyan).Add(color.Bold),
+		},
+		{
+			color.New(color.FgHiGreen).Add(color.Bold),
+			color.New(color.FgGreen).Add(color.Bold),
+		},
+		{
+			color.New(color.FgHiMagenta).Add(color.Bold),
+			color.New(color.FgMagenta).Add(color.Bold),
+		},
+		{
+			color.New(color.FgHiRed).Add(color.Bold),
+			color.New(color.FgRed).Add(color.Bold),
+		},
+		{
+			color.New(color.FgHiYellow).Add(color.Bold),
+			color.New(color.FgYellow).Add(color.Bold),
+		},
 	},
 }
 
-func getColorConfig(parts ...string) colorConfig {
+// xterm256Colors are readable xterm-256 color indexes, ordered by hue.
+var xterm256Colors = []int{
+	196, 202, 208, 214, 220, 226, 190, 154, 118, 82, 46, 48,
+	50, 51, 45, 39, 33, 27, 63, 99, 135, 171, 207, 201, 199, 198,
+}
+
+// truecolorHues is the number of evenly spaced hues in the truecolor palette.
+const truecolorHues = 30
+
+func newPalette(name string) (colorPalette, error) {
+	switch name {
+	case "", "8":
+		return basicPalette, nil
+	case "256":
+		palette := colorPalette{hueOrdered: true}
+		for _, c := range xterm256Colors {
+			palette.configs = append(palette.configs, colorConfig{
+				color.New(38, 5, color.Attribute(c)).Add(color.Bold),
+				color.New(38, 5, color.Attribute(c)),
+			})
+		}
+		return palette, nil
+	case "truecolor":
+		palette := colorPalette{hueOrdered: true}
+		for i := 0; i < truecolorHues; i++ {
+			hue := float64(i) * 360 / truecolorHues
+			r, g, b := hsvToRGB(hue, 0.65, 1.0)
+			mr, mg, mb := hsvToRGB(hue, 0.65, 0.8)
+			palette.configs = append(palette.configs, colorConfig{
+				color.New(38, 2, color.Attribute(r), color.Attribute(g), color.Attribute(b)).Add(color.Bold),
+				color.New(38, 2, color.Attribute(mr), color.Attribute(mg), color.Attribute(mb)),
+			})
+		}
+		return palette, nil
+	}
+	return colorPalette{}, fmt.Errorf("invalid palette %q, expected one of 8, 256 or truecolor", name)
+}
+
+// colorAssigner assigns palette colors to keys. The preferred color of a key
+// is derived from its hash, so colors are stable across runs, but if the
+// preferred color (or, for hue-ordered palettes, a similar one) is already
+// used by another visible stream, the next free color is picked instead.
+type colorAssigner struct {
+	palette  colorPalette
+	assigned map[string]int
+	refs     map[string]int
+	used     []int
+	sync.Mutex
+}
+
+func newColorAssigner(palette colorPalette) *colorAssigner {
+	return &colorAssigner{
+		palette:  palette,
+		assigned: map[string]int{},
+		refs:     map[string]int{},
+		used:     make([]int, len(palette.configs)),
+	}
+}
+
+// acquire marks a key as visible, assigning it a color if needed.
+func (ca *colorAssigner) acquire(parts ...string) {
+	ca.Lock()
+	defer ca.Unlock()
+
+	key := strings.Join(parts, "\x00")
+	ca.refs[key]++
+	if _, ok := ca.assigned[key]; !ok {
+		index := ca.pick(parts)
+		ca.assigned[key] = index
+		ca.used[index]++
+	}
+}
+
+// release marks a key as no longer visible, freeing its color once all users
+// are gone.
+func (ca *colorAssigner) release(parts ...string) {
+	ca.Lock()
+	defer ca.Unlock()
+
+	key := strings.Join(parts, "\x00")
+	if ca.refs[key] == 0 {
+		return
+	}
+	ca.refs[key]--
+	if ca.refs[key] == 0 {
+		delete(ca.refs, key)
+		ca.used[ca.assigned[key]]--
+		delete(ca.assigned, key)
+	}
+}
+
+func (ca *colorAssigner) get(parts ...string) colorConfig {
+	ca.Lock()
+	defer ca.Unlock()
+
+	index, ok := ca.assigned[strings.Join(parts, "\x00")]
+	if !ok {
+		index = ca.pick(parts)
+	}
+	return ca.palette.configs[index]
+}
+
+func (ca *colorAssigner) pick(parts []string) int {
 	hash := fnv.New32()
 	for _, a := range parts {
 		_, _ = hash.Write([]byte(a))
 	}
-	return colorConfigs[hash.Sum32()%uint32(len(colorConfigs))]
+	n := len(ca.palette.configs)
+	preferred := int(hash.Sum32() % uint32(n))
+
+	best, bestScore := preferred, -1
+	for i := 0; i < n; i++ {
+		candidate := (preferred + i) % n
+		score := ca.used[candidate] * 4
+		if ca.palette.hueOrdered {
+			score += ca.used[(candidate+n-1)%n] + ca.used[(candidate+1)%n]
+		}
+		if score == 0 {
+			return candidate
+		}
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+func hsvToRGB(h, s, v float64) (uint8, uint8, uint8) {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255)
+}
+
+// parseColorKey parses a --color-by expression. Valid values are "stream"
+// (each container of each pod), "pod", "namespace", "container" (container
+// name, shared across replicas) and "label:NAME".
+func parseColorKey(s string) (colorKeyFunc, error) {
+	switch s {
+	case "", "stream":
+		return func(pod *v1.Pod, container *v1.Container) []string {
+			return []string{pod.Name, container.Name}
+		}, nil
+	case "pod":
+		return func(pod *v1.Pod, container *v1.Container) []string {
+			return []string{pod.Namespace, pod.Name}
+		}, nil
+	case "namespace":
+		return func(pod *v1.Pod, container *v1.Container) []string {
+			return []string{pod.Namespace}
+		}, nil
+	case "container":
+		return func(pod *v1.Pod, container *v1.Container) []string {
+			return []string{container.Name}
+		}, nil
+	}
+	if label, ok := strings.CutPrefix(s, "label:"); ok && label != "" {
+		return func(pod *v1.Pod, container *v1.Container) []string {
+			return []string{pod.Namespace, pod.Labels[label]}
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid color key %q", s)
 }
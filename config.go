@@ -1,22 +1,111 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
 )
 
+// clusterConfigKey is the ConfigMap key that holds cluster-provided defaults.
+const clusterConfigKey = "config.yml"
+
+// defaultExclusions are well-known sidecar containers that are excluded unless
+// overridden in the config file or disabled with --no-default-exclusions.
+var defaultExclusions = []string{
+	"istio-proxy",
+	"istio-init",
+	"linkerd-proxy",
+	"linkerd-init",
+	"envoy",
+	"vault-agent",
+	"vault-agent-init",
+}
+
 type Config struct {
-	Quiet          bool   `yaml:"quiet"`
-	NoColor        bool   `yaml:"noColor"`
-	Raw            bool   `yaml:"raw"`
-	Timestamps     bool   `yaml:"timestamps"`
-	ColorMode      string `yaml:"colorMode"`
-	ColorScheme    string `yaml:"colorScheme"`
-	TemplateString string `yaml:"templateString"`
-	KubeConfigPath string `yaml:"kubeConfigPath"`
+	Quiet               bool        `yaml:"quiet"`
+	NoColor             bool        `yaml:"noColor"`
+	Raw                 bool        `yaml:"raw"`
+	Timestamps          bool        `yaml:"timestamps"`
+	LineNumbers         bool        `yaml:"lineNumbers"`
+	ShortPrefixes       bool        `yaml:"shortPrefixes"`
+	ColorMode           string      `yaml:"colorMode"`
+	ColorScheme         string      `yaml:"colorScheme"`
+	ColorBy             string      `yaml:"colorBy"`
+	Palette             string      `yaml:"palette"`
+	TemplateString      string      `yaml:"templateString"`
+	Output              string      `yaml:"output"`
+	IncludeLabels       []string    `yaml:"includeLabels"`
+	Columns             []string    `yaml:"columns"`
+	IncludeAnnotations  []string    `yaml:"includeAnnotations"`
+	KubeConfigPath      string      `yaml:"kubeConfigPath"`
+	DefaultExclusions   []string    `yaml:"defaultExclusions"`
+	NoDefaultExclusions bool        `yaml:"noDefaultExclusions"`
+	Exclude             []string    `yaml:"exclude"`
+	ExcludeNamespaces   []string    `yaml:"excludeNamespaces"`
+	ClusterConfig       string      `yaml:"clusterConfig"`
+	Routes              []Route     `yaml:"routes"`
+	Redactions          []Redaction `yaml:"redactions"`
+	Overrides           []Override  `yaml:"overrides"`
+}
+
+// Route sends the lines of matching containers to a file instead of standard
+// output. Namespace must match the whole namespace name; Pod and Container
+// match like patterns given on the command line.
+type Route struct {
+	Namespace string `yaml:"namespace"`
+	Pod       string `yaml:"pod"`
+	Container string `yaml:"container"`
+	File      string `yaml:"file"`
+	Output    string `yaml:"output"`
+}
+
+// Override changes how matching containers are tailed. Namespace, Pod and
+// Container match like in routes. Every matching override applies, with later
+// ones taking priority.
+type Override struct {
+	Namespace string `yaml:"namespace"`
+	Pod       string `yaml:"pod"`
+	Container string `yaml:"container"`
+
+	// TailLines limits how many lines of history are shown when attaching.
+	TailLines *int64 `yaml:"tailLines"`
+
+	// RateLimit is the number of lines per second above which lines are
+	// dropped.
+	RateLimit *float64 `yaml:"rateLimit"`
+
+	// Retry bounds the interval between attempts to re-establish a stream.
+	Retry *RetryPolicy `yaml:"retry"`
+
+	// Parser is how fields are extracted from messages: "json" (the
+	// default), "logfmt", or "none".
+	Parser string `yaml:"parser"`
+}
+
+type RetryPolicy struct {
+	Min metav1.Duration `yaml:"min"`
+	Max metav1.Duration `yaml:"max"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ColorMode:         "auto",
+		ColorScheme:       "bw",
+		ColorBy:           "stream",
+		Palette:           "8",
+		Output:            "text",
+		DefaultExclusions: defaultExclusions,
+	}
 }
 
 func (c *Config) LoadDefault() error {
@@ -41,3 +130,114 @@ func (c *Config) LoadFromPath(path string) error {
 	}
 	return nil
 }
+
+// LoadDefaultOver loads the local config file on top of the config, which
+// holds cluster defaults. Exclusions and redactions add to those of the
+// cluster, rather than replace them, so that nobody drops the team's
+// entries by accident.
+func (c *Config) LoadDefaultOver() error {
+	cluster := *c
+	c.Exclude, c.ExcludeNamespaces, c.Redactions = nil, nil, nil
+	if err := c.LoadDefault(); err != nil {
+		return err
+	}
+	c.Exclude = append(cluster.Exclude, c.Exclude...)
+	c.ExcludeNamespaces = append(cluster.ExcludeNamespaces, c.ExcludeNamespaces...)
+	c.Redactions = append(cluster.Redactions, c.Redactions...)
+	return nil
+}
+
+// ApplyToFlags sets the flags that take their defaults from the config to
+// the config's values, except those that were given explicitly. A flag's
+// aliases are listed with it, and count as giving it.
+func (c *Config) ApplyToFlags(flags *pflag.FlagSet) error {
+	for _, f := range []struct {
+		names []string
+		value interface{}
+	}{
+		{[]string{"quiet"}, c.Quiet},
+		{[]string{"raw"}, c.Raw},
+		{[]string{"timestamps"}, c.Timestamps},
+		{[]string{"line-numbers"}, c.LineNumbers},
+		{[]string{"short-prefixes"}, c.ShortPrefixes},
+		{[]string{"no-color"}, c.NoColor},
+		{[]string{"color", "colour"}, c.ColorMode},
+		{[]string{"color-scheme", "colour-scheme"}, c.ColorScheme},
+		{[]string{"color-by"}, c.ColorBy},
+		{[]string{"palette"}, c.Palette},
+		{[]string{"template"}, c.TemplateString},
+		{[]string{"output"}, c.Output},
+		{[]string{"columns"}, c.Columns},
+		{[]string{"include-labels"}, c.IncludeLabels},
+		{[]string{"include-annotations"}, c.IncludeAnnotations},
+		{[]string{"no-default-exclusions"}, c.NoDefaultExclusions},
+	} {
+		changed := false
+		for _, name := range f.names {
+			changed = changed || flags.Changed(name)
+		}
+		if changed {
+			continue
+		}
+		flag := flags.Lookup(f.names[0])
+		var err error
+		switch v := f.value.(type) {
+		case bool:
+			err = flag.Value.Set(strconv.FormatBool(v))
+		case string:
+			err = flag.Value.Set(v)
+		case []string:
+			err = flag.Value.(pflag.SliceValue).Replace(v)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", f.names[0], err)
+		}
+	}
+	return nil
+}
+
+// sharedConfig is the part of the config that a cluster ConfigMap can
+// provide. Anything else, like routes, which write files, or the kubeconfig
+// path, is only read from the local config file.
+type sharedConfig struct {
+	Exclude           []string    `yaml:"exclude"`
+	ExcludeNamespaces []string    `yaml:"excludeNamespaces"`
+	DefaultExclusions []string    `yaml:"defaultExclusions"`
+	Redactions        []Redaction `yaml:"redactions"`
+	TemplateString    string      `yaml:"templateString"`
+}
+
+// LoadFromConfigMap loads shared defaults from a ConfigMap given as
+// "namespace/name". A missing or unreadable ConfigMap is not an error, but
+// one with keys that aren't shared settings is.
+func (c *Config) LoadFromConfigMap(ctx context.Context, client kubernetes.Interface, ref string) error {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("invalid config map reference %q, expected namespace/name", ref)
+	}
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) || errors.IsForbidden(err) {
+			return nil
+		}
+		return fmt.Errorf("reading config map %q: %w", ref, err)
+	}
+	data, ok := configMap.Data[clusterConfigKey]
+	if !ok {
+		return nil
+	}
+	var shared sharedConfig
+	if err := yaml.UnmarshalStrict([]byte(data), &shared); err != nil {
+		return fmt.Errorf("parsing config map %q: %w", ref, err)
+	}
+	c.Exclude = append(c.Exclude, shared.Exclude...)
+	c.ExcludeNamespaces = append(c.ExcludeNamespaces, shared.ExcludeNamespaces...)
+	c.Redactions = append(c.Redactions, shared.Redactions...)
+	if shared.DefaultExclusions != nil {
+		c.DefaultExclusions = shared.DefaultExclusions
+	}
+	if shared.TemplateString != "" {
+		c.TemplateString = shared.TemplateString
+	}
+	return nil
+}
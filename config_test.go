@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newConfigMapClient(data string) *fake.Clientset {
+	return fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: "ktail-config"},
+		Data:       map[string]string{clusterConfigKey: data},
+	})
+}
+
+func TestLoadFromConfigMap(t *testing.T) {
+	client := newConfigMapClient(`
+exclude: [istio-proxy]
+excludeNamespaces: [kube-system]
+defaultExclusions: []
+redactions:
+  - name: tokens
+    pattern: "token=\\S+"
+templateString: "{{.Message}}"
+`)
+	cfg := defaultConfig()
+	cfg.Exclude = []string{"local"}
+	if err := cfg.LoadFromConfigMap(context.Background(), client, "kube-public/ktail-config"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Exclude, ",") != "local,istio-proxy" {
+		t.Errorf("unexpected exclusions %v", cfg.Exclude)
+	}
+	if strings.Join(cfg.ExcludeNamespaces, ",") != "kube-system" {
+		t.Errorf("unexpected namespace exclusions %v", cfg.ExcludeNamespaces)
+	}
+	if cfg.DefaultExclusions == nil || len(cfg.DefaultExclusions) != 0 {
+		t.Errorf("expected default exclusions to be cleared, got %v", cfg.DefaultExclusions)
+	}
+	if len(cfg.Redactions) != 1 || cfg.Redactions[0].Name != "tokens" {
+		t.Errorf("unexpected redactions %v", cfg.Redactions)
+	}
+	if cfg.TemplateString != "{{.Message}}" {
+		t.Errorf("unexpected template %q", cfg.TemplateString)
+	}
+}
+
+func TestLoadFromConfigMapRejectsLocalSettings(t *testing.T) {
+	for _, data := range []string{
+		"routes: [{file: /tmp/out.log}]",
+		"overrides: [{container: app}]",
+		"kubeConfigPath: /tmp/kubeconfig",
+		"clusterConfig: other/config",
+		"output: json",
+	} {
+		t.Run(data, func(t *testing.T) {
+			cfg := defaultConfig()
+			err := cfg.LoadFromConfigMap(context.Background(), newConfigMapClient(data), "kube-public/ktail-config")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if len(cfg.Routes) != 0 || len(cfg.Overrides) != 0 || cfg.KubeConfigPath != "" || cfg.ClusterConfig != "" {
+				t.Errorf("config was changed: %+v", cfg)
+			}
+		})
+	}
+}
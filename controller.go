@@ -3,30 +3,74 @@ package main
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
 	"k8s.io/client-go/kubernetes"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/internalversion"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
+// maxConcurrentNamespaceStarts limits how many namespaces are listed at once
+// when starting.
+const maxConcurrentNamespaceStarts = 10
+
 type ControllerOptions struct {
 	Namespaces       []string
+	Nodes            []string
 	InclusionMatcher Matcher
 	ExclusionMatcher Matcher
 	SinceStart       bool
 	Since            *time.Time
+	Tailer           TailerOptions
+
+	// MaxHistory, if not zero, limits how far back in time any stream is
+	// read, whatever else decides where tailing starts.
+	MaxHistory time.Duration
+
+	// SinceAnnotations lets pods override where tailing starts with the
+	// sinceAnnotation annotation.
+	SinceAnnotations bool
+
+	// MatchLabels attaches a label to the events of the pods selected by each
+	// matcher. If several match, the first one wins.
+	MatchLabels []LabeledMatcher
+
+	// WaitForRunning defers attaching to a container until it's running,
+	// calling OnWaiting while it's waiting instead of trying to get its logs.
+	WaitForRunning bool
+
+	// TailerOverrides adjust the tailer options of the containers that match
+	// them.
+	TailerOverrides tailerOverrides
+
+	// NewClient, if set, is used to build a new client when the API server
+	// rejects the current client's credentials.
+	NewClient func() (kubernetes.Interface, error)
 }
 
 type (
 	ContainerEnterFunc func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool
 	ContainerExitFunc  func(pod *v1.Pod, container *v1.Container)
 	ContainerErrorFunc func(pod *v1.Pod, container *v1.Container, err error)
+	ContainerRateFunc  func(pod *v1.Pod, container *v1.Container, bytesPerSecond float64)
+	ContainerWaitFunc  func(pod *v1.Pod, container *v1.Container, reason string)
+	ContainerInitFunc  func(pod *v1.Pod, container *v1.Container, step, steps int)
+	ContainerCountFunc func(pod *v1.Pod, container *v1.Container, count int64)
+	PodFunc            func(pod *v1.Pod)
+
+	// LogEventMiddleware may enrich or alter an event before it's passed
+	// on, or drop it by returning false.
+	LogEventMiddleware func(event LogEvent) (LogEvent, bool)
 )
 
 type Callbacks struct {
@@ -34,147 +78,610 @@ type Callbacks struct {
 	OnEnter             ContainerEnterFunc
 	OnExit              ContainerExitFunc
 	OnError             ContainerErrorFunc
+	OnHighThroughput    ContainerRateFunc
+	OnWaiting           ContainerWaitFunc
+	OnKubeletFallback   ContainerErrorFunc
+	OnRateLimited       ContainerCountFunc
+	OnInitContainer     ContainerInitFunc
+	OnInitialized       PodFunc
 	OnNothingDiscovered func()
+	OnNamespaceMissing  func(namespace string)
+	OnNamespaceCreated  func(namespace string)
+
+	// Middleware is run on every event, in order, before OnEvent.
+	Middleware []LogEventMiddleware
+}
+
+// chainMiddleware returns an event func that runs events through a chain of
+// middleware before passing them to next.
+func chainMiddleware(middleware []LogEventMiddleware, next LogEventFunc) LogEventFunc {
+	if len(middleware) == 0 {
+		return next
+	}
+	return func(event LogEvent) {
+		for _, m := range middleware {
+			var ok bool
+			if event, ok = m(event); !ok {
+				return
+			}
+		}
+		next(event)
+	}
+}
+
+// MatcherKind says whether a matcher added at runtime widens or narrows the
+// set of tailed containers.
+type MatcherKind int
+
+const (
+	IncludeMatcher MatcherKind = iota
+	ExcludeMatcher
+)
+
+// MatcherID identifies a matcher added with AddMatcher.
+type MatcherID int
+
+type runtimeMatcher struct {
+	id      MatcherID
+	kind    MatcherKind
+	matcher Matcher
 }
 
 type Controller struct {
 	ControllerOptions
-	client    kubernetes.Interface
-	tailers   map[string]*ContainerTailer
-	callbacks Callbacks
+	client        kubernetes.Interface
+	tailers       map[string]*ContainerTailer
+	streams       map[string]string
+	initializing  map[string]bool
+	entering      map[*ContainerTailer]bool
+	waiting       map[string]string
+	pods          map[string]*v1.Pod
+	synced        map[string]bool
+	matchers      []runtimeMatcher
+	lastMatcherID MatcherID
+	matchersLock  sync.RWMutex
+	callbacks     Callbacks
+	clientChanged chan struct{}
+	lastRefresh   time.Time
+	refreshLock   sync.Mutex
 	sync.Mutex
 }
 
+// minClientRefreshInterval is the minimum time between rebuilding the client
+// because of rejected credentials.
+const minClientRefreshInterval = 10 * time.Second
+
+// errClientChanged ends a session of the controller when the client is
+// replaced.
+var errClientChanged = fmt.Errorf("client changed")
+
 func NewController(client kubernetes.Interface, options ControllerOptions, callbacks Callbacks) *Controller {
+	// Overlapping namespaces or nodes would list and watch the same pods twice
+	options.Namespaces = uniqueNamespaces(options.Namespaces)
+	options.Nodes = uniqueStrings(options.Nodes)
+	callbacks.OnEvent = chainMiddleware(callbacks.Middleware, callbacks.OnEvent)
 	return &Controller{
 		ControllerOptions: options,
 		client:            client,
 		tailers:           map[string]*ContainerTailer{},
+		streams:           map[string]string{},
+		initializing:      map[string]bool{},
+		entering:          map[*ContainerTailer]bool{},
+		waiting:           map[string]string{},
+		pods:              map[string]*v1.Pod{},
+		synced:            map[string]bool{},
 		callbacks:         callbacks,
+		clientChanged:     make(chan struct{}, 1),
+	}
+}
+
+// uniqueNamespaces removes duplicate namespaces. If any namespace is
+// v1.NamespaceAll, it's the only one returned, since it covers all others.
+func uniqueNamespaces(namespaces []string) []string {
+	if slices.Contains(namespaces, v1.NamespaceAll) {
+		return []string{v1.NamespaceAll}
 	}
+	return uniqueStrings(namespaces)
+}
+
+// uniqueStrings removes duplicates from a slice, keeping the first occurrence
+// of each.
+func uniqueStrings(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// SetClient replaces the client after switching to another cluster or
+// context. Every tailer is stopped, since its pod belongs to the old cluster,
+// and pods are listed and watched again using the new client.
+func (ctl *Controller) SetClient(client kubernetes.Interface) {
+	ctl.Lock()
+	ctl.client = client
+	for key, tailer := range ctl.tailers {
+		ctl.removeTailer(buildContainerRef(&tailer.pod, &tailer.container), key)
+		ctl.detach(tailer, &tailer.pod, &tailer.container, false, false)
+	}
+	ctl.streams = map[string]string{}
+	ctl.initializing = map[string]bool{}
+	ctl.waiting = map[string]string{}
+	ctl.pods = map[string]*v1.Pod{}
+	ctl.Unlock()
+
+	select {
+	case ctl.clientChanged <- struct{}{}:
+	default:
+	}
+}
+
+// refreshClient rebuilds the client after its credentials were rejected, in
+// case they have been updated in the kubeconfig since the client was built.
+func (ctl *Controller) refreshClient() {
+	if ctl.NewClient == nil {
+		return
+	}
+
+	ctl.refreshLock.Lock()
+	defer ctl.refreshLock.Unlock()
+	if time.Since(ctl.lastRefresh) < minClientRefreshInterval {
+		return
+	}
+	ctl.lastRefresh = time.Now()
+
+	client, err := ctl.NewClient()
+	if err != nil {
+		return
+	}
+	// Only the credentials have changed, so there's no need to list and watch
+	// pods again: informers and tailers pick up the new client the next time
+	// they make a request
+	ctl.UpdateCredentials(client)
+}
+
+// UpdateCredentials replaces the client of the controller and its tailers
+// when only its credentials have changed, without listing pods again.
+func (ctl *Controller) UpdateCredentials(client kubernetes.Interface) {
+	ctl.Lock()
+	defer ctl.Unlock()
+	ctl.client = client
+	for _, tailer := range ctl.tailers {
+		tailer.SetClient(client)
+	}
+}
+
+func (ctl *Controller) getClient() kubernetes.Interface {
+	ctl.Lock()
+	defer ctl.Unlock()
+	return ctl.client
 }
 
 func (ctl *Controller) Run(ctx context.Context) error {
+	initial := true
+	for {
+		sessionCtx, cancel := context.WithCancel(ctx)
+		err := ctl.runSession(sessionCtx, initial)
+		cancel()
+		if err != errClientChanged {
+			return err
+		}
+		initial = false
+	}
+}
+
+// runSession lists and watches pods until the context is cancelled or the
+// client changes.
+func (ctl *Controller) runSession(ctx context.Context, initial bool) error {
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	discoveredAny := false
+	errCh := make(chan error, len(ctl.Namespaces))
+
+	ctl.Lock()
+	ctl.synced = map[string]bool{}
+	ctl.Unlock()
+
+	// Namespaces are started concurrently, since listing each one in turn is
+	// slow when there are many
+	var (
+		listed        = map[string]bool{}
+		discoveredAny bool
+		firstErr      error
+		lock          sync.Mutex
+		wg            sync.WaitGroup
+	)
+	sem := make(chan struct{}, maxConcurrentNamespaceStarts)
 	for _, ns := range ctl.Namespaces {
-		podListWatcher := cache.NewListWatchFromClient(
-			ctl.client.CoreV1().RESTClient(), "pods", ns, fields.Everything())
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			nsListed := map[string]bool{}
+			discovered, err := ctl.startNamespace(ctx, ns, stopCh, initial, errCh, nsListed)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for key := range nsListed {
+				listed[key] = true
+			}
+			discoveredAny = discoveredAny || discovered
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if initial {
+		if !discoveredAny {
+			ctl.callbacks.OnNothingDiscovered()
+		}
+	} else {
+		ctl.removeUnlisted(listed)
+	}
 
-		obj, err := podListWatcher.List(metav1.ListOptions{})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	case <-ctl.clientChanged:
+		return errClientChanged
+	}
+}
+
+// startNamespace starts tailing the pods in a namespace, recording them in
+// listed. If the namespace doesn't exist yet, it waits for it to be created in
+// the background, sending any error to errCh.
+func (ctl *Controller) startNamespace(
+	ctx context.Context,
+	ns string,
+	stopCh <-chan struct{},
+	initial bool,
+	errCh chan<- error,
+	listed map[string]bool) (bool, error) {
+	if ns != v1.NamespaceAll {
+		exists, err := ctl.namespaceExists(ctx, ns)
 		if err != nil {
-			return fmt.Errorf("listing pods in %q: %w", ns, err)
+			return false, err
 		}
-		switch t := obj.(type) {
-		case *v1.PodList:
-			for _, pod := range t.Items {
-				if ctl.onInitialAdd(&pod) {
-					discoveredAny = true
-				}
+		if !exists {
+			if initial && ctl.callbacks.OnNamespaceMissing != nil {
+				ctl.callbacks.OnNamespaceMissing(ns)
 			}
-		case *internalversion.List:
-			for _, item := range t.Items {
-				if pod, ok := item.(*v1.Pod); ok {
-					if ctl.onInitialAdd(pod) {
-						discoveredAny = true
-					}
+			go func() {
+				if err := ctl.waitForNamespace(ctx, ns, stopCh); err != nil {
+					errCh <- err
 				}
-			}
-		default:
-			panic(fmt.Sprintf("unexpected return type %T when listing pods", obj))
+			}()
+			return false, nil
 		}
+	}
+	return ctl.startInformers(ns, stopCh, true, listed)
+}
 
-		_, informer := cache.NewIndexerInformer(
-			podListWatcher, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
-				AddFunc: func(obj interface{}) {
-					if pod, ok := obj.(*v1.Pod); ok {
-						ctl.onAdd(pod)
-					}
-				},
-				UpdateFunc: func(old interface{}, new interface{}) {
-					if pod, ok := new.(*v1.Pod); ok {
-						ctl.onUpdate(pod)
-					}
-				},
-				DeleteFunc: func(obj interface{}) {
-					if pod, ok := obj.(*v1.Pod); ok {
-						ctl.onDelete(pod)
-					}
-				},
-			}, cache.Indexers{})
+// removeUnlisted stops tailing pods that were not found when listing pods
+// again with a new client.
+func (ctl *Controller) removeUnlisted(listed map[string]bool) {
+	ctl.Lock()
+	var gone []*ContainerTailer
+	for _, tailer := range ctl.tailers {
+		if !listed[tailer.pod.Namespace+"/"+tailer.pod.Name] {
+			gone = append(gone, tailer)
+		}
+	}
+	ctl.Unlock()
 
-		go informer.Run(stopCh)
+	for _, tailer := range gone {
+		ctl.deleteContainer(&tailer.pod, &tailer.container)
 	}
 
-	if !discoveredAny {
-		ctl.callbacks.OnNothingDiscovered()
+	ctl.Lock()
+	for key := range ctl.pods {
+		if !listed[key] {
+			delete(ctl.pods, key)
+		}
 	}
+	ctl.Unlock()
+}
 
-	<-ctx.Done()
-	return ctx.Err()
+// namespaceExists checks whether a namespace exists. If the namespace can't be
+// read, it's assumed to exist.
+func (ctl *Controller) namespaceExists(ctx context.Context, ns string) (bool, error) {
+	_, err := ctl.getClient().CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.IsNotFound(err):
+		return false, nil
+	case errors.IsForbidden(err):
+		return true, nil
+	}
+	return false, fmt.Errorf("getting namespace %q: %w", ns, err)
 }
 
-func (ctl *Controller) onInitialAdd(pod *v1.Pod) bool {
-	added := false
-	for _, container := range pod.Spec.InitContainers {
-		if ctl.shouldIncludeContainer(pod, &container) {
-			ctl.addContainer(pod, &container, true)
-			added = true
+// waitForNamespace watches for a namespace to be created, and then starts
+// tailing its pods.
+func (ctl *Controller) waitForNamespace(ctx context.Context, ns string, stopCh <-chan struct{}) error {
+	namespaces := ctl.getClient().CoreV1().Namespaces()
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", ns).String()
+	listWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return namespaces.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return namespaces.Watch(ctx, options)
+		},
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, listWatcher, &v1.Namespace{}, nil,
+		func(event watch.Event) (bool, error) {
+			return event.Type == watch.Added, nil
+		})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
 		}
+		return fmt.Errorf("waiting for namespace %q: %w", ns, err)
 	}
-	for _, container := range pod.Spec.Containers {
-		if ctl.shouldIncludeContainer(pod, &container) {
-			ctl.addContainer(pod, &container, true)
-			added = true
+
+	if ctl.callbacks.OnNamespaceCreated != nil {
+		ctl.callbacks.OnNamespaceCreated(ns)
+	}
+	_, err = ctl.startInformers(ns, stopCh, false, nil)
+	return err
+}
+
+// startInformers lists and watches the pods in a namespace, returning whether
+// any containers were discovered. If listed is not nil, the listed pods are
+// recorded in it.
+func (ctl *Controller) startInformers(
+	ns string,
+	stopCh <-chan struct{},
+	initialAdd bool,
+	listed map[string]bool) (bool, error) {
+	discoveredAny := false
+	for _, fieldSelector := range ctl.fieldSelectors() {
+		discovered, err := ctl.startInformer(ns, fieldSelector, stopCh, initialAdd, listed)
+		if err != nil {
+			return false, err
+		}
+		if discovered {
+			discoveredAny = true
 		}
 	}
-	return added
+
+	ctl.Lock()
+	ctl.synced[ns] = true
+	ctl.Unlock()
+	return discoveredAny, nil
 }
 
-func (ctl *Controller) onAdd(pod *v1.Pod) {
-	for _, container := range pod.Spec.InitContainers {
-		if ctl.shouldIncludeContainer(pod, &container) {
-			ctl.addContainer(pod, &container, false)
+// ControllerStats describes what the controller is currently doing.
+type ControllerStats struct {
+	Namespaces       int
+	NamespacesSynced int
+	Pods             int
+	Streams          int
+	StreamStats      []StreamStats
+}
+
+func (ctl *Controller) Stats() ControllerStats {
+	ctl.Lock()
+	defer ctl.Unlock()
+
+	pods := map[string]bool{}
+	streamStats := make([]StreamStats, 0, len(ctl.tailers))
+	for _, tailer := range ctl.tailers {
+		pods[tailer.pod.Namespace+"/"+tailer.pod.Name] = true
+		streamStats = append(streamStats, tailer.Stats())
+	}
+	return ControllerStats{
+		Namespaces:       len(ctl.Namespaces),
+		NamespacesSynced: len(ctl.synced),
+		Pods:             len(pods),
+		Streams:          len(ctl.tailers),
+		StreamStats:      streamStats,
+	}
+}
+
+// fieldSelectors returns the field selectors to list and watch pods with. A
+// field selector can only match a single node name, so each node gets its own
+// list-watch.
+func (ctl *Controller) fieldSelectors() []fields.Selector {
+	if len(ctl.Nodes) == 0 {
+		return []fields.Selector{fields.Everything()}
+	}
+	selectors := make([]fields.Selector, len(ctl.Nodes))
+	for i, node := range ctl.Nodes {
+		selectors[i] = fields.OneTermEqualSelector("spec.nodeName", node)
+	}
+	return selectors
+}
+
+func (ctl *Controller) startInformer(
+	ns string,
+	fieldSelector fields.Selector,
+	stopCh <-chan struct{},
+	initialAdd bool,
+	listed map[string]bool) (bool, error) {
+	podListWatcher := ctl.podListWatch(ns, fieldSelector)
+
+	discoveredAny := false
+	obj, err := podListWatcher.List(metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("listing pods in %q: %w", ns, err)
+	}
+	switch t := obj.(type) {
+	case *v1.PodList:
+		for _, pod := range t.Items {
+			if listed != nil {
+				listed[pod.Namespace+"/"+pod.Name] = true
+			}
+			if ctl.addPod(&pod, initialAdd) {
+				discoveredAny = true
+			}
 		}
+	case *internalversion.List:
+		for _, item := range t.Items {
+			if pod, ok := item.(*v1.Pod); ok {
+				if listed != nil {
+					listed[pod.Namespace+"/"+pod.Name] = true
+				}
+				if ctl.addPod(pod, initialAdd) {
+					discoveredAny = true
+				}
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unexpected return type %T when listing pods", obj))
 	}
-	for _, container := range pod.Spec.Containers {
-		if ctl.shouldIncludeContainer(pod, &container) {
-			ctl.addContainer(pod, &container, false)
+
+	_, informer := cache.NewIndexerInformer(
+		podListWatcher, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if pod, ok := obj.(*v1.Pod); ok {
+					ctl.onAdd(pod)
+				}
+			},
+			UpdateFunc: func(old interface{}, new interface{}) {
+				if pod, ok := new.(*v1.Pod); ok {
+					ctl.onUpdate(pod)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				// The deletion may have been missed while the watch was down
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if pod, ok := obj.(*v1.Pod); ok {
+					ctl.onDelete(pod)
+				}
+			},
+		}, cache.Indexers{})
+
+	go informer.Run(stopCh)
+	return discoveredAny, nil
+}
+
+// podListWatch lists and watches the pods in a namespace. This goes through
+// the typed client rather than its REST client, as cache.NewListWatchFromClient
+// would, because that works with any kubernetes.Interface; the fake clientset
+// used by --bench has no REST client. The client is looked up on every call, so
+// that refreshed credentials are used when the informer lists or watches again.
+func (ctl *Controller) podListWatch(ns string, fieldSelector fields.Selector) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector.String()
+			return ctl.getClient().CoreV1().Pods(ns).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector.String()
+			return ctl.getClient().CoreV1().Pods(ns).Watch(context.Background(), options)
+		},
+	}
+}
+
+func (ctl *Controller) onAdd(pod *v1.Pod) {
+	ctl.addPod(pod, false)
+}
+
+func (ctl *Controller) addPod(pod *v1.Pod, initialAdd bool) bool {
+	ctl.trackPod(pod)
+	ctl.markInitialized(pod)
+	added := false
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if !ctl.shouldIncludeContainer(pod, container) {
+				continue
+			}
+			if reason, ok := ctl.deferredWaiting(pod, container); ok {
+				ctl.noteWaiting(pod, container, reason)
+			} else {
+				ctl.addContainer(pod, container, initialAdd)
+				added = true
+			}
 		}
 	}
+	return added
 }
 
 func (ctl *Controller) onUpdate(pod *v1.Pod) {
-	containers := pod.Spec.Containers
+	ctl.trackPod(pod)
+	ctl.markInitialized(pod)
 	containerStatuses := allContainerStatusesForPod(pod)
 	for _, containerStatus := range containerStatuses {
-		var container *v1.Container
-		for i, c := range containers {
-			if c.Name == containerStatus.Name {
-				container = &containers[i]
-				break
-			}
-		}
+		container := findContainer(pod, containerStatus.Name)
 		if container == nil {
 			// Should be impossible; means there's a status for a container that isn't
 			// part of the spec
 			continue
 		}
 
-		if ctl.shouldIncludeContainer(pod, container) {
-			ctl.addContainer(pod, container, false)
-		} else {
+		if !ctl.shouldIncludeContainer(pod, container) {
 			ctl.deleteContainer(pod, container)
+		} else if reason, ok := ctl.deferredWaiting(pod, container); ok {
+			ctl.noteWaiting(pod, container, reason)
+		} else {
+			ctl.addContainer(pod, container, false)
 		}
 	}
 }
 
 func (ctl *Controller) onDelete(pod *v1.Pod) {
+	for _, container := range pod.Spec.InitContainers {
+		ctl.deleteContainer(pod, &container)
+	}
 	for _, container := range pod.Spec.Containers {
 		ctl.deleteContainer(pod, &container)
 	}
+
+	ctl.Lock()
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			delete(ctl.waiting, buildContainerRef(pod, &containers[i]))
+		}
+	}
+	delete(ctl.initializing, pod.Namespace+"/"+pod.Name)
+	delete(ctl.pods, pod.Namespace+"/"+pod.Name)
+	ctl.Unlock()
+}
+
+// trackPod records the latest version of a pod, so that callbacks about its
+// containers can report their current status.
+func (ctl *Controller) trackPod(pod *v1.Pod) {
+	ctl.Lock()
+	ctl.pods[pod.Namespace+"/"+pod.Name] = pod
+	ctl.Unlock()
+}
+
+// currentPod returns the latest known version of a pod.
+func (ctl *Controller) currentPod(pod *v1.Pod) *v1.Pod {
+	ctl.Lock()
+	defer ctl.Unlock()
+	if current, ok := ctl.pods[pod.Namespace+"/"+pod.Name]; ok && current.UID == pod.UID {
+		return current
+	}
+	return pod
 }
 
 func (ctl *Controller) shouldIncludeContainer(pod *v1.Pod, container *v1.Container) bool {
@@ -182,6 +689,17 @@ func (ctl *Controller) shouldIncludeContainer(pod *v1.Pod, container *v1.Contain
 		return false
 	}
 
+	// Init containers are attached one at a time, as each one starts, and
+	// main containers once they have all completed, so that their output
+	// doesn't interleave
+	if index := initContainerIndex(pod, container.Name); index >= 0 {
+		if !initContainerStarted(pod, index) {
+			return false
+		}
+	} else if podInitializing(pod) {
+		return false
+	}
+
 	running := false
 	for _, s := range allContainerStatusesForPod(pod) {
 		if s.Name == container.Name && (s.State.Waiting != nil || s.State.Terminated != nil ||
@@ -194,13 +712,128 @@ func (ctl *Controller) shouldIncludeContainer(pod *v1.Pod, container *v1.Contain
 		return false
 	}
 
-	if ctl.ExclusionMatcher.Match(pod) {
+	return ctl.matches(pod, container)
+}
+
+// deferredWaiting returns whether attaching to an included container is
+// deferred because it's waiting to run, and why it's waiting. Only containers
+// that aren't tailed yet are deferred; one that goes back to waiting, such as
+// after crashing, is left to be drained and detached as usual.
+func (ctl *Controller) deferredWaiting(pod *v1.Pod, container *v1.Container) (string, bool) {
+	if !ctl.WaitForRunning {
+		return "", false
+	}
+	reason, waiting := containerWaiting(pod, container)
+	if !waiting {
+		return "", false
+	}
+	ctl.Lock()
+	_, tailed := ctl.streams[buildContainerRef(pod, container)]
+	ctl.Unlock()
+	return reason, !tailed
+}
+
+// noteWaiting calls OnWaiting when a container that isn't attached yet starts
+// waiting, or waits for another reason.
+func (ctl *Controller) noteWaiting(pod *v1.Pod, container *v1.Container, reason string) {
+	ref := buildContainerRef(pod, container)
+	ctl.Lock()
+	previous, ok := ctl.waiting[ref]
+	ctl.waiting[ref] = reason
+	ctl.Unlock()
+
+	if (!ok || previous != reason) && ctl.callbacks.OnWaiting != nil {
+		ctl.callbacks.OnWaiting(pod, container, reason)
+	}
+}
+
+// matches checks a container against the inclusion and exclusion matchers.
+func (ctl *Controller) matches(pod *v1.Pod, container *v1.Container) bool {
+	inclusion, exclusion := ctl.effectiveMatchers()
+	if exclusion.Match(pod) {
 		return false
 	}
-	if !(ctl.InclusionMatcher.Match(pod) || ctl.InclusionMatcher.Match(container)) {
+	if !(inclusion.Match(pod) || inclusion.Match(container)) {
 		return false
 	}
-	return !ctl.ExclusionMatcher.Match(container)
+	return !exclusion.Match(container)
+}
+
+// AddMatcher adds a matcher while running. An include matcher tails containers
+// in addition to those matched by the inclusion matcher, and an exclude
+// matcher stops tailing the containers it matches. Known pods are evaluated
+// again straight away, without restarting the informers.
+func (ctl *Controller) AddMatcher(kind MatcherKind, matcher Matcher) MatcherID {
+	ctl.matchersLock.Lock()
+	ctl.lastMatcherID++
+	id := ctl.lastMatcherID
+	ctl.matchers = append(ctl.matchers, runtimeMatcher{id: id, kind: kind, matcher: matcher})
+	ctl.matchersLock.Unlock()
+
+	ctl.reevaluate()
+	return id
+}
+
+// RemoveMatcher removes a matcher added with AddMatcher, and evaluates known
+// pods again. It returns false if there is no such matcher.
+func (ctl *Controller) RemoveMatcher(id MatcherID) bool {
+	ctl.matchersLock.Lock()
+	found := false
+	for i, m := range ctl.matchers {
+		if m.id == id {
+			ctl.matchers = append(ctl.matchers[:i], ctl.matchers[i+1:]...)
+			found = true
+			break
+		}
+	}
+	ctl.matchersLock.Unlock()
+
+	if found {
+		ctl.reevaluate()
+	}
+	return found
+}
+
+// effectiveMatchers combines the configured matchers with those added at
+// runtime.
+func (ctl *Controller) effectiveMatchers() (Matcher, Matcher) {
+	ctl.matchersLock.RLock()
+	defer ctl.matchersLock.RUnlock()
+
+	inclusion, exclusion := ctl.InclusionMatcher, ctl.ExclusionMatcher
+	for _, m := range ctl.matchers {
+		switch m.kind {
+		case IncludeMatcher:
+			inclusion = or{inclusion, m.matcher}
+		case ExcludeMatcher:
+			exclusion = or{exclusion, m.matcher}
+		}
+	}
+	return inclusion, exclusion
+}
+
+// reevaluate attaches to and detaches from containers of known pods after
+// the matchers have changed. Newly matched containers are tailed from now.
+func (ctl *Controller) reevaluate() {
+	ctl.Lock()
+	pods := make([]*v1.Pod, 0, len(ctl.pods))
+	for _, pod := range ctl.pods {
+		pods = append(pods, pod)
+	}
+	ctl.Unlock()
+
+	for _, pod := range pods {
+		for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for i := range containers {
+				container := &containers[i]
+				if !ctl.shouldIncludeContainer(pod, container) {
+					ctl.deleteContainer(pod, container)
+				} else if _, ok := ctl.deferredWaiting(pod, container); !ok {
+					ctl.addContainer(pod, container, true)
+				}
+			}
+		}
+	}
 }
 
 func (ctl *Controller) addContainer(pod *v1.Pod, container *v1.Container, initialAdd bool) {
@@ -212,41 +845,278 @@ func (ctl *Controller) addContainer(pod *v1.Pod, container *v1.Container, initia
 		return
 	}
 
-	if !ctl.callbacks.OnEnter(pod, container, initialAdd) {
+	// The start time is resolved first, since a container that can't be
+	// tailed yet mustn't enter; there would be no exit to match
+	fromTimestamp, ok := ctl.getStartTimestamp(pod, container, initialAdd)
+	if !ok {
 		return
 	}
 
-	fromTimestamp, ok := ctl.getStartTimestamp(pod, container, initialAdd)
-	if !ok {
+	// A pod that was recreated with the same name, or a container that has
+	// restarted, is a new stream; stop tailing the previous one
+	var previousExited <-chan struct{}
+	ref := buildContainerRef(pod, container)
+	if previousKey, ok := ctl.streams[ref]; ok {
+		if previous := ctl.removeTailer(ref, previousKey); previous != nil {
+			if previous.pod.UID == pod.UID {
+				previousExited = ctl.detach(previous, pod, container, true, true)
+			} else {
+				ctl.detach(previous, &previous.pod, &previous.container, false, false)
+			}
+		}
+	}
+
+	// A restarted container enters once its previous run has exited, below
+	if previousExited == nil && !ctl.callbacks.OnEnter(pod, container, initialAdd) {
 		return
 	}
 
+	ctl.markInitPhase(pod, container, initialAdd)
+	delete(ctl.waiting, ref)
+
 	targetPod, targetContainer := *pod, *container // Copy to avoid mutation
 
+	eventFunc := ctl.callbacks.OnEvent
+	if label := matchLabel(ctl.MatchLabels, &targetPod); label != "" {
+		eventFunc = func(event LogEvent) {
+			event.MatchLabel = label
+			ctl.callbacks.OnEvent(event)
+		}
+	}
+
 	tailer := NewContainerTailer(ctl.client, targetPod, targetContainer,
-		ctl.callbacks.OnEvent, fromTimestamp)
-	ctl.tailers[key] = tailer
+		eventFunc, fromTimestamp, ctl.TailerOverrides.apply(&targetPod, &targetContainer, ctl.Tailer))
+
+	if previousExited == nil {
+		ctl.tailers[key] = tailer
+		ctl.streams[ref] = key
+		go ctl.runTailer(tailer, &targetPod, &targetContainer)
+		return
+	}
 
+	// The previous run's final lines and exit come before this run enters, so
+	// it waits for them. Meanwhile, the tailer is registered so that it isn't
+	// added twice, but it's not entered until it starts.
+	ctl.tailers[key] = tailer
+	ctl.streams[ref] = key
+	ctl.entering[tailer] = true
 	go func() {
-		tailer.Run(context.Background(), func(err error) {
-			ctl.callbacks.OnError(&targetPod, &targetContainer, err)
-		})
+		<-previousExited
+
+		ctl.Lock()
+		if !ctl.entering[tailer] {
+			// Removed while waiting
+			ctl.Unlock()
+			return
+		}
+		delete(ctl.entering, tailer)
+		entered := ctl.callbacks.OnEnter(&targetPod, &targetContainer, initialAdd)
+		if !entered {
+			ctl.removeTailer(ref, key)
+		}
+		ctl.Unlock()
+
+		if entered {
+			ctl.runTailer(tailer, &targetPod, &targetContainer)
+		}
 	}()
 }
 
+// runTailer runs a tailer until it's stopped, relaying its callbacks.
+func (ctl *Controller) runTailer(tailer *ContainerTailer, targetPod *v1.Pod, targetContainer *v1.Container) {
+	tailer.Run(context.Background(), TailerCallbacks{
+		OnError: func(err error) {
+			ctl.callbacks.OnError(ctl.currentPod(targetPod), targetContainer, err)
+		},
+		OnRateLimited: func(dropped int64) {
+			if ctl.callbacks.OnRateLimited != nil {
+				ctl.callbacks.OnRateLimited(targetPod, targetContainer, dropped)
+			}
+		},
+		OnHighThroughput: func(bytesPerSecond float64) {
+			if ctl.callbacks.OnHighThroughput != nil {
+				ctl.callbacks.OnHighThroughput(targetPod, targetContainer, bytesPerSecond)
+			}
+		},
+		OnWaiting: func(reason string) {
+			if ctl.callbacks.OnWaiting != nil {
+				ctl.callbacks.OnWaiting(targetPod, targetContainer, reason)
+			}
+		},
+		OnUnauthorized: ctl.refreshClient,
+		OnKubeletFallback: func(err error) {
+			if ctl.callbacks.OnKubeletFallback != nil {
+				ctl.callbacks.OnKubeletFallback(targetPod, targetContainer, err)
+			}
+		},
+		CurrentPod: func() *v1.Pod {
+			return ctl.currentPod(targetPod)
+		},
+	})
+}
+
+// markInitPhase reports the start of each of a pod's init containers as it's
+// attached to. Pods that had already initialized when first listed are not
+// reported. Must be called with the lock held.
+func (ctl *Controller) markInitPhase(pod *v1.Pod, container *v1.Container, initialAdd bool) {
+	index := initContainerIndex(pod, container.Name)
+	if index < 0 || (initialAdd && !podInitializing(pod)) {
+		return
+	}
+	ctl.initializing[pod.Namespace+"/"+pod.Name] = true
+	if ctl.callbacks.OnInitContainer != nil {
+		ctl.callbacks.OnInitContainer(pod, container, index+1, len(pod.Spec.InitContainers))
+	}
+}
+
+// markInitialized reports the point at which a pod whose init containers
+// were reported has completed them, and its main containers start.
+func (ctl *Controller) markInitialized(pod *v1.Pod) {
+	podKey := pod.Namespace + "/" + pod.Name
+	last := len(pod.Spec.InitContainers) - 1
+	if last < 0 || !(initContainerCompleted(pod, last) || !podInitializing(pod)) {
+		return
+	}
+
+	ctl.Lock()
+	initializing := ctl.initializing[podKey]
+	delete(ctl.initializing, podKey)
+	ctl.Unlock()
+
+	if initializing && ctl.callbacks.OnInitialized != nil {
+		ctl.callbacks.OnInitialized(pod)
+	}
+}
+
 func (ctl *Controller) deleteContainer(pod *v1.Pod, container *v1.Container) {
 	ctl.Lock()
 	defer ctl.Unlock()
 
-	key := buildKey(pod, container)
-	if tailer, ok := ctl.tailers[key]; ok {
-		delete(ctl.tailers, key)
-		tailer.Stop()
+	ref := buildContainerRef(pod, container)
+	key, ok := ctl.streams[ref]
+	if !ok {
+		return
+	}
+	// The stream may belong to a newer pod with the same name, in which case
+	// this is a late event about the old one
+	if tailer := ctl.tailers[key]; tailer != nil && tailer.pod.UID != pod.UID {
+		return
+	}
+	if tailer := ctl.removeTailer(ref, key); tailer != nil {
+		terminated := containerTerminated(pod, container) || podDisruptionReason(pod) != ""
+		ctl.detach(tailer, pod, container, terminated, false)
+	}
+}
+
+// detach calls OnExit for a removed tailer. If the container was terminated,
+// its final lines are read first, so that they come before the exit, which
+// makes the exit asynchronous. The returned channel is closed once OnExit has
+// been called. Must be called with the lock held.
+func (ctl *Controller) detach(
+	tailer *ContainerTailer,
+	pod *v1.Pod,
+	container *v1.Container,
+	terminated, restarted bool) <-chan struct{} {
+	exited := make(chan struct{})
+	if ctl.entering[tailer] {
+		// Never entered, so there's nothing to read or report
+		delete(ctl.entering, tailer)
+		close(exited)
+		return exited
+	}
+	if !terminated {
 		ctl.callbacks.OnExit(pod, container)
+		close(exited)
+		return exited
+	}
+	go func() {
+		defer close(exited)
+		tailer.Drain(restarted)
+		ctl.callbacks.OnExit(pod, container)
+	}()
+	return exited
+}
+
+// containerTerminated returns whether a container has terminated.
+func containerTerminated(pod *v1.Pod, container *v1.Container) bool {
+	for _, status := range allContainerStatusesForPod(pod) {
+		if status.Name == container.Name {
+			return status.State.Terminated != nil
+		}
+	}
+	return false
+}
+
+// podDisruptionReason returns why a pod was evicted, preempted or otherwise
+// disrupted, if it was.
+func podDisruptionReason(pod *v1.Pod) string {
+	if pod.Status.Phase == v1.PodFailed && pod.Status.Reason != "" {
+		return pod.Status.Reason
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.DisruptionTarget && condition.Status == v1.ConditionTrue {
+			return condition.Reason
+		}
 	}
+	return ""
+}
+
+// removeTailer stops a tailer, returning it, or nil if there is none. Must be
+// called with the lock held.
+func (ctl *Controller) removeTailer(ref, key string) *ContainerTailer {
+	tailer, ok := ctl.tailers[key]
+	delete(ctl.streams, ref)
+	if !ok {
+		return nil
+	}
+	delete(ctl.tailers, key)
+	tailer.Stop()
+	return tailer
+}
+
+// sinceAnnotation lets a pod choose how much history is shown when it is
+// tailed, as a time or a duration like --since, or "start". The annotation
+// can be suffixed with "." and a container name to apply to one container.
+const sinceAnnotation = "ktail.dev/since"
+
+// annotatedStartTimestamp returns the start time requested by the pod's
+// annotations, if any. A nil time means from the start.
+func annotatedStartTimestamp(pod *v1.Pod, container *v1.Container) (*time.Time, bool) {
+	value, ok := pod.Annotations[sinceAnnotation+"."+container.Name]
+	if !ok {
+		value, ok = pod.Annotations[sinceAnnotation]
+	}
+	if !ok {
+		return nil, false
+	}
+	if value == "start" {
+		return nil, true
+	}
+	t, err := parseSinceExpr(value)
+	if err != nil || t == nil {
+		return nil, false
+	}
+	return t, true
 }
 
 func (ctl *Controller) getStartTimestamp(pod *v1.Pod, container *v1.Container, initialAdd bool) (*time.Time, bool) {
+	t, ok := ctl.getUncappedStartTimestamp(pod, container, initialAdd)
+	if ok && ctl.MaxHistory > 0 {
+		limit := time.Now().Add(-ctl.MaxHistory)
+		if t == nil || t.Before(limit) {
+			t = &limit
+		}
+	}
+	return t, ok
+}
+
+func (ctl *Controller) getUncappedStartTimestamp(pod *v1.Pod, container *v1.Container, initialAdd bool) (*time.Time, bool) {
+	if ctl.SinceAnnotations {
+		if t, ok := annotatedStartTimestamp(pod, container); ok {
+			return t, true
+		}
+	}
+
 	switch {
 	case ctl.SinceStart:
 		return nil, true
@@ -268,16 +1138,127 @@ func (ctl *Controller) getStartTimestamp(pod *v1.Pod, container *v1.Container, i
 			}
 		}
 		if t == nil {
+			// An init container that's about to start has no logs yet, so
+			// all of them can be read once it does
+			if index := initContainerIndex(pod, container.Name); index >= 0 && initContainerStarted(pod, index) {
+				return nil, true
+			}
 			return nil, false
 		}
 		return t, true
 	}
 }
 
+// buildKey identifies a stream of logs: a single run of a container in a
+// single incarnation of a pod.
 func buildKey(pod *v1.Pod, container *v1.Container) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%d", pod.Namespace, pod.Name, pod.UID, container.Name,
+		containerRestartCount(pod, container))
+}
+
+// buildContainerRef identifies a container by name, regardless of how many
+// times it or its pod has been recreated.
+func buildContainerRef(pod *v1.Pod, container *v1.Container) string {
 	return fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, container.Name)
 }
 
+func containerRestartCount(pod *v1.Pod, container *v1.Container) int32 {
+	for _, status := range allContainerStatusesForPod(pod) {
+		if status.Name == container.Name {
+			return status.RestartCount
+		}
+	}
+	return 0
+}
+
+// containerWaiting returns why a container is waiting, if it is.
+func containerWaiting(pod *v1.Pod, container *v1.Container) (string, bool) {
+	for _, status := range allContainerStatusesForPod(pod) {
+		if status.Name == container.Name && status.State.Waiting != nil {
+			reason := status.State.Waiting.Reason
+			if reason == "" {
+				reason = "waiting"
+			}
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// containerStartTime returns when the current run of a container started, or
+// nil if it hasn't.
+func containerStartTime(pod *v1.Pod, container *v1.Container) *time.Time {
+	for _, status := range allContainerStatusesForPod(pod) {
+		if status.Name != container.Name {
+			continue
+		}
+		switch {
+		case status.State.Running != nil:
+			return &status.State.Running.StartedAt.Time
+		case status.State.Terminated != nil:
+			return &status.State.Terminated.StartedAt.Time
+		}
+	}
+	return nil
+}
+
+func findContainer(pod *v1.Pod, name string) *v1.Container {
+	if index := initContainerIndex(pod, name); index >= 0 {
+		return &pod.Spec.InitContainers[index]
+	}
+	for i, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func initContainerIndex(pod *v1.Pod, name string) int {
+	for i, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// initContainerStarted returns whether an init container is running or has
+// run, or is about to run because the one before it has completed. Every init
+// container has a waiting status from when the pod is created, even though
+// they run one at a time.
+func initContainerStarted(pod *v1.Pod, index int) bool {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name == pod.Spec.InitContainers[index].Name &&
+			(status.State.Running != nil || status.State.Terminated != nil) {
+			return true
+		}
+	}
+	return index > 0 && initContainerCompleted(pod, index-1)
+}
+
+// initContainerCompleted returns whether an init container has completed
+// successfully.
+func initContainerCompleted(pod *v1.Pod, index int) bool {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name == pod.Spec.InitContainers[index].Name {
+			return status.State.Terminated != nil && status.State.Terminated.ExitCode == 0
+		}
+	}
+	return false
+}
+
+// podInitializing returns true if the pod's init containers have not all
+// completed yet.
+func podInitializing(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodInitialized {
+			return condition.Status != v1.ConditionTrue
+		}
+	}
+	return false
+}
+
 func allContainerStatusesForPod(pod *v1.Pod) []v1.ContainerStatus {
 	statuses := make([]v1.ContainerStatus, len(pod.Status.ContainerStatuses)+len(pod.Status.InitContainerStatuses))
 	return append(
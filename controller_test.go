@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestChainMiddleware(t *testing.T) {
+	enrich := func(suffix string) LogEventMiddleware {
+		return func(event LogEvent) (LogEvent, bool) {
+			event.Message += suffix
+			return event, true
+		}
+	}
+	dropQuiet := func(event LogEvent) (LogEvent, bool) {
+		return event, !strings.HasPrefix(event.Message, "quiet")
+	}
+
+	for _, test := range []struct {
+		name       string
+		middleware []LogEventMiddleware
+		message    string
+		expected   []string
+	}{
+		{"none", nil, "hello", []string{"hello"}},
+		{"enrich", []LogEventMiddleware{enrich(" a")}, "hello", []string{"hello a"}},
+		{"in order", []LogEventMiddleware{enrich(" a"), enrich(" b")}, "hello", []string{"hello a b"}},
+		{"kept", []LogEventMiddleware{dropQuiet, enrich(" a")}, "hello", []string{"hello a"}},
+		{"dropped", []LogEventMiddleware{dropQuiet, enrich(" a")}, "quiet", nil},
+		{"dropped after enrich", []LogEventMiddleware{enrich(" a"), dropQuiet}, "quiet", nil},
+		{"enrich sees earlier changes", []LogEventMiddleware{enrich("quiet "), dropQuiet}, "", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var received []string
+			next := chainMiddleware(test.middleware, func(event LogEvent) {
+				received = append(received, event.Message)
+			})
+			next(LogEvent{Message: test.message})
+			if strings.Join(received, "|") != strings.Join(test.expected, "|") || len(received) != len(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, received)
+			}
+		})
+	}
+}
+
+func TestControllerRunsMiddleware(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	client := newBenchClientset(10, 20, done)
+	if err := client.Tracker().Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: benchNamespace},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Tracker().Add(newBenchPod(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	seen := map[string]int{}
+	received := map[string]int{}
+	controller := NewController(client,
+		ControllerOptions{
+			Namespaces:       []string{benchNamespace},
+			InclusionMatcher: trueMatcher{},
+			ExclusionMatcher: falseMatcher{},
+			SinceStart:       true,
+		},
+		Callbacks{
+			Middleware: []LogEventMiddleware{
+				func(event LogEvent) (LogEvent, bool) {
+					lock.Lock()
+					defer lock.Unlock()
+					seen[event.Container.Name]++
+					if seen["container-0"]+seen["container-1"] == 20 {
+						defer cancel()
+					}
+					return event, event.Container.Name == "container-0"
+				},
+				func(event LogEvent) (LogEvent, bool) {
+					event.MatchLabel = "enriched"
+					return event, true
+				},
+			},
+			OnEvent: func(event LogEvent) {
+				lock.Lock()
+				defer lock.Unlock()
+				received[event.Container.Name+"/"+event.MatchLabel]++
+			},
+			OnEnter: func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool {
+				return true
+			},
+			OnExit:              func(pod *v1.Pod, container *v1.Container) {},
+			OnNothingDiscovered: func() {},
+			OnError: func(pod *v1.Pod, container *v1.Container, err error) {
+				t.Errorf("tailing %s/%s: %s", pod.Name, container.Name, err)
+			},
+		})
+	_ = controller.Run(ctx)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if seen["container-0"] != 10 || seen["container-1"] != 10 {
+		t.Errorf("expected middleware to see 10 lines per container, got %v", seen)
+	}
+	if len(received) != 1 || received["container-0/enriched"] != 10 {
+		t.Errorf("expected only enriched lines from container-0, got %v", received)
+	}
+}
+
+func TestAddContainerDoesNotEnterWithoutStartTime(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	entered := 0
+	controller := NewController(newBenchClientset(1, 10, done),
+		ControllerOptions{
+			Namespaces:       []string{benchNamespace},
+			InclusionMatcher: trueMatcher{},
+			ExclusionMatcher: falseMatcher{},
+		},
+		Callbacks{
+			OnEvent: func(event LogEvent) {},
+			OnEnter: func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool {
+				entered++
+				return true
+			},
+			OnExit: func(pod *v1.Pod, container *v1.Container) {
+				t.Errorf("unexpected exit of %s", container.Name)
+			},
+		})
+
+	// A container that is crash looping has no running state to start from
+	pod := newBenchPod(0, 1)
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{
+		Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+	}
+	for i := 0; i < 3; i++ {
+		controller.addContainer(pod, &pod.Spec.Containers[0], false)
+	}
+	if entered != 0 {
+		t.Errorf("expected no enter, got %d", entered)
+	}
+	if len(controller.tailers) != 0 {
+		t.Errorf("expected no tailers, got %d", len(controller.tailers))
+	}
+}
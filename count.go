@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// matchCounter counts matching lines per container, for --count.
+type matchCounter struct {
+	counts map[string]int64
+	sync.Mutex
+}
+
+func newMatchCounter() *matchCounter {
+	return &matchCounter{
+		counts: map[string]int64{},
+	}
+}
+
+// track makes a container appear in reports even before it has any matches.
+func (c *matchCounter) track(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.counts[name]; !ok {
+		c.counts[name] = 0
+	}
+}
+
+func (c *matchCounter) add(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.counts[name]++
+}
+
+// Run writes a report at every interval until the context is cancelled.
+func (c *matchCounter) Run(ctx context.Context, interval time.Duration, w io.Writer) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if err := c.report(w, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *matchCounter) report(w io.Writer, now time.Time) error {
+	c.Lock()
+	names := make([]string, 0, len(c.counts))
+	for name := range c.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := make([]int64, len(names))
+	var total int64
+	for i, name := range names {
+		counts[i] = c.counts[name]
+		total += counts[i]
+	}
+	c.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "%s\tTOTAL\t%d\n", formatTimestamp(&now), total)
+	for i, name := range names {
+		_, _ = fmt.Fprintf(tw, "\t%s\t%d\n", name, counts[i])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// outputBufferSize is the size of the buffer for block-buffered output.
+const outputBufferSize = 64 * 1024
+
+// flushWriter buffers output. In line-buffered mode, every write is flushed
+// immediately; otherwise, output is flushed when the buffer is full, and
+// periodically by Run.
+type flushWriter struct {
+	writer       *bufio.Writer
+	lineBuffered bool
+	sync.Mutex
+}
+
+func newFlushWriter(w io.Writer, lineBuffered bool) *flushWriter {
+	return &flushWriter{
+		writer:       bufio.NewWriterSize(w, outputBufferSize),
+		lineBuffered: lineBuffered,
+	}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.Lock()
+	defer fw.Unlock()
+	n, err := fw.writer.Write(p)
+	if err == nil && fw.lineBuffered {
+		err = fw.writer.Flush()
+	}
+	return n, err
+}
+
+func (fw *flushWriter) Flush() error {
+	fw.Lock()
+	defer fw.Unlock()
+	return fw.writer.Flush()
+}
+
+// Run flushes buffered output at every interval until the context is
+// cancelled.
+func (fw *flushWriter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := fw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseOutputBuffering returns whether output should be line-buffered, given
+// a buffering mode of "auto", "line", or "block". Output is line-buffered in
+// auto mode when it goes to a terminal.
+func parseOutputBuffering(mode string, terminal bool) (bool, error) {
+	switch mode {
+	case "auto":
+		return terminal, nil
+	case "line":
+		return true, nil
+	case "block":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown buffering mode %q", mode)
+}
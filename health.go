@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// exitCodeDegraded is the exit status with --strict-exit when the capture is
+// incomplete.
+const exitCodeDegraded = 4
+
+// healthTracker records problems that leave a capture incomplete, such as
+// missing permissions or output that couldn't be written.
+type healthTracker struct {
+	problems []string
+	sync.Mutex
+}
+
+func (h *healthTracker) report(format string, args ...interface{}) {
+	problem := fmt.Sprintf(format, args...)
+
+	h.Lock()
+	defer h.Unlock()
+	if !slices.Contains(h.problems, problem) {
+		h.problems = append(h.problems, problem)
+	}
+}
+
+func (h *healthTracker) Problems() []string {
+	h.Lock()
+	defer h.Unlock()
+	return slices.Clone(h.problems)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHistogramBuckets is the number of most recent buckets shown for
+	// each container.
+	maxHistogramBuckets = 60
+
+	// histogramBarWidth is the width of the longest bar.
+	histogramBarWidth = 50
+)
+
+// histogram counts lines per container in fixed time buckets, by the
+// timestamp of each line, for --histogram.
+type histogram struct {
+	bucketSize time.Duration
+	buckets    map[string]map[int64]int64
+	sync.Mutex
+}
+
+func newHistogram(bucketSize time.Duration) *histogram {
+	return &histogram{
+		bucketSize: bucketSize,
+		buckets:    map[string]map[int64]int64{},
+	}
+}
+
+func (h *histogram) add(name string, t time.Time) {
+	bucket := t.Truncate(h.bucketSize).UnixNano()
+
+	h.Lock()
+	defer h.Unlock()
+	counts, ok := h.buckets[name]
+	if !ok {
+		counts = map[int64]int64{}
+		h.buckets[name] = counts
+	}
+	counts[bucket]++
+}
+
+// Run writes the chart at every interval until the context is cancelled.
+func (h *histogram) Run(ctx context.Context, interval time.Duration, w io.Writer) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := h.render(w); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *histogram) render(w io.Writer) error {
+	h.Lock()
+	defer h.Unlock()
+
+	names := make([]string, 0, len(h.buckets))
+	for name := range h.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var maxCount int64
+	for _, counts := range h.buckets {
+		for _, count := range counts {
+			maxCount = max(maxCount, count)
+		}
+	}
+
+	var sb strings.Builder
+	for _, name := range names {
+		counts := h.buckets[name]
+		first, last := int64(0), int64(0)
+		for bucket := range counts {
+			if first == 0 || bucket < first {
+				first = bucket
+			}
+			last = max(last, bucket)
+		}
+		step := h.bucketSize.Nanoseconds()
+		first = max(first, last-step*(maxHistogramBuckets-1))
+
+		sb.WriteString(name)
+		sb.WriteByte('\n')
+		for bucket := first; bucket <= last; bucket += step {
+			count := counts[bucket]
+			width := int(count * histogramBarWidth / maxCount)
+			if width == 0 && count > 0 {
+				width = 1
+			}
+			fmt.Fprintf(&sb, "  %s  %-*s %d\n", h.formatBucket(time.Unix(0, bucket)),
+				histogramBarWidth, strings.Repeat("█", width), count)
+		}
+	}
+	sb.WriteByte('\n')
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (h *histogram) formatBucket(t time.Time) string {
+	t = t.Local()
+	switch {
+	case h.bucketSize >= 24*time.Hour:
+		return t.Format("2006-01-02")
+	case h.bucketSize%time.Minute == 0:
+		return t.Format("2006-01-02 15:04")
+	default:
+		return t.Format("2006-01-02 15:04:05")
+	}
+}
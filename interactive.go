@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+const interactiveHelp = `Commands:
+  /PATTERN         Highlight text matching PATTERN ("/" alone clears)
+  filter PATTERN   Only show lines matching PATTERN ("filter" alone clears)
+  mute PATTERN     Hide streams (pod:container) matching PATTERN
+  unmute [PATTERN] Show muted streams again (all if no pattern)
+  include PATTERN  Also tail pods and containers matching PATTERN
+  exclude PATTERN  Stop tailing pods and containers matching PATTERN
+  drop ID          Undo an include or exclude command
+  mark [NOTE]      Insert a timestamped marker into the output
+  status           Show the current search, filter, muted streams, and scope
+  help             Show this help`
+
+var colorSearchMatch = color.New(color.ReverseVideo).SprintFunc()
+
+// sessionFilter holds search and filter state that can be changed with
+// commands while tailing. The state lasts for the session.
+type sessionFilter struct {
+	search *regexp.Regexp
+	filter *regexp.Regexp
+	muted  []*regexp.Regexp
+	onMark func(note string)
+
+	controller *Controller
+	scope      map[MatcherID]string
+	sync.RWMutex
+}
+
+// allow returns true if an event from the named stream should be shown.
+func (f *sessionFilter) allow(name string, event *LogEvent) bool {
+	f.RLock()
+	defer f.RUnlock()
+	for _, m := range f.muted {
+		if m.MatchString(name) {
+			return false
+		}
+	}
+	return f.filter == nil || f.filter.MatchString(event.Message)
+}
+
+// highlight marks the parts of a message that match the current search.
+func (f *sessionFilter) highlight(message string) string {
+	f.RLock()
+	search := f.search
+	f.RUnlock()
+	if search == nil || color.NoColor {
+		return message
+	}
+	return search.ReplaceAllStringFunc(message, func(s string) string {
+		return colorSearchMatch(s)
+	})
+}
+
+// Run reads commands, one per line, until the reader is closed.
+func (f *sessionFilter) Run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := f.execute(strings.TrimSpace(scanner.Text())); err != nil {
+			printError("%s", err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (f *sessionFilter) execute(line string) error {
+	if strings.HasPrefix(line, "/") {
+		return f.setSearch(line[1:])
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case "":
+		return nil
+	case "filter":
+		return f.setFilter(arg)
+	case "mute":
+		return f.mute(arg)
+	case "unmute":
+		return f.unmute(arg)
+	case "include", "exclude":
+		return f.changeScope(command, arg)
+	case "drop":
+		return f.dropScope(arg)
+	case "mark":
+		if f.onMark != nil {
+			f.onMark(arg)
+		}
+	case "status":
+		f.printStatus()
+	case "help", "?":
+		printInfo("%s", interactiveHelp)
+	default:
+		return fmt.Errorf("unknown command %q; type 'help' for a list of commands", command)
+	}
+	return nil
+}
+
+func (f *sessionFilter) setSearch(pattern string) error {
+	r, err := compileOptional(pattern)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	f.search = r
+	f.Unlock()
+	if r == nil {
+		printInfo("Search cleared")
+	} else {
+		printInfo("Highlighting %q", pattern)
+	}
+	return nil
+}
+
+func (f *sessionFilter) setFilter(pattern string) error {
+	r, err := compileOptional(pattern)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	f.filter = r
+	f.Unlock()
+	if r == nil {
+		printInfo("Filter cleared")
+	} else {
+		printInfo("Only showing lines matching %q", pattern)
+	}
+	return nil
+}
+
+func (f *sessionFilter) mute(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("mute requires a pattern")
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	f.Lock()
+	f.muted = append(f.muted, r)
+	f.Unlock()
+	printInfo("Muted streams matching %q", pattern)
+	return nil
+}
+
+func (f *sessionFilter) unmute(pattern string) error {
+	f.Lock()
+	defer f.Unlock()
+	if pattern == "" {
+		f.muted = nil
+		printInfo("Unmuted all streams")
+		return nil
+	}
+	for i, m := range f.muted {
+		if m.String() == pattern {
+			f.muted = append(f.muted[:i], f.muted[i+1:]...)
+			printInfo("Unmuted streams matching %q", pattern)
+			return nil
+		}
+	}
+	return fmt.Errorf("no streams are muted with %q", pattern)
+}
+
+func (f *sessionFilter) changeScope(command, pattern string) error {
+	if f.controller == nil {
+		return fmt.Errorf("not tailing yet")
+	}
+	if pattern == "" {
+		return fmt.Errorf("%s requires a pattern", command)
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	kind := IncludeMatcher
+	if command == "exclude" {
+		kind = ExcludeMatcher
+	}
+	id := f.controller.AddMatcher(kind, regexMatcher{regexp: r})
+
+	f.Lock()
+	if f.scope == nil {
+		f.scope = map[MatcherID]string{}
+	}
+	f.scope[id] = command + " " + pattern
+	f.Unlock()
+	printInfo("Added %s %q as #%d", command, pattern, id)
+	return nil
+}
+
+func (f *sessionFilter) dropScope(arg string) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return fmt.Errorf("drop requires the number of an include or exclude command")
+	}
+	if f.controller == nil || !f.controller.RemoveMatcher(MatcherID(id)) {
+		return fmt.Errorf("there is no include or exclude #%d", id)
+	}
+
+	f.Lock()
+	description := f.scope[MatcherID(id)]
+	delete(f.scope, MatcherID(id))
+	f.Unlock()
+	printInfo("Dropped #%d (%s)", id, description)
+	return nil
+}
+
+func (f *sessionFilter) printStatus() {
+	f.RLock()
+	defer f.RUnlock()
+
+	var sb strings.Builder
+	sb.WriteString("Search: ")
+	if f.search != nil {
+		sb.WriteString(f.search.String())
+	} else {
+		sb.WriteString("(none)")
+	}
+	sb.WriteString("\nFilter: ")
+	if f.filter != nil {
+		sb.WriteString(f.filter.String())
+	} else {
+		sb.WriteString("(none)")
+	}
+	sb.WriteString("\nMuted:  ")
+	if len(f.muted) > 0 {
+		for i, m := range f.muted {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(m.String())
+		}
+	} else {
+		sb.WriteString("(none)")
+	}
+	ids := make([]int, 0, len(f.scope))
+	for id := range f.scope {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&sb, "\nScope:  #%d %s", id, f.scope[MatcherID(id)])
+	}
+	printInfo("%s", sb.String())
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return r, nil
+}
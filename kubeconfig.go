@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeConfigPollInterval is how often kubeconfig files are checked for
+// changes.
+const kubeConfigPollInterval = 2 * time.Second
+
+func newClientConfig(
+	loadingRules *clientcmd.ClientConfigLoadingRules,
+	contextName string) clientcmd.ClientConfig {
+	return clientcmd.NewInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{
+			CurrentContext: contextName,
+		},
+		nil)
+}
+
+func newClientset(clientConfig clientcmd.ClientConfig) (*rest.Config, kubernetes.Interface, error) {
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set higher rate limits
+	config.QPS = 100
+	config.Burst = 100
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, clientset, nil
+}
+
+// watchKubeConfig polls the kubeconfig files for changes. When the effective
+// client configuration changes, such as after a credential refresh or when
+// another tool switches the current context, onChange is called with a new
+// client, and whether it talks to another cluster than before.
+func watchKubeConfig(
+	ctx context.Context,
+	loadingRules *clientcmd.ClientConfigLoadingRules,
+	contextName string,
+	config *rest.Config,
+	onChange func(client kubernetes.Interface, contextName string, host string, clusterChanged bool),
+	onError func(err error)) {
+	fingerprint := kubeConfigFingerprint(loadingRules)
+	lastContext := currentContextName(loadingRules, contextName)
+
+	ticker := time.NewTicker(kubeConfigPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		newFingerprint := kubeConfigFingerprint(loadingRules)
+		if newFingerprint == fingerprint {
+			continue
+		}
+		fingerprint = newFingerprint
+
+		clientConfig := newClientConfig(loadingRules, contextName)
+		newConfig, client, err := newClientset(clientConfig)
+		if err != nil {
+			// The file may be partially written; try again on the next change
+			onError(fmt.Errorf("reloading kubeconfig: %w", err))
+			continue
+		}
+		if reflect.DeepEqual(newConfig, config) {
+			continue
+		}
+		clusterChanged := newConfig.Host != config.Host
+		config = newConfig
+
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			onError(fmt.Errorf("reloading kubeconfig: %w", err))
+			continue
+		}
+		currentContext := contextName
+		if currentContext == "" {
+			currentContext = rawConfig.CurrentContext
+		}
+		clusterChanged = clusterChanged || currentContext != lastContext
+		lastContext = currentContext
+		onChange(client, currentContext, newConfig.Host, clusterChanged)
+	}
+}
+
+// currentContextName returns the name of the context in use: contextName if
+// given, and otherwise the kubeconfig's current context.
+func currentContextName(loadingRules *clientcmd.ClientConfigLoadingRules, contextName string) string {
+	if contextName != "" {
+		return contextName
+	}
+	rawConfig, err := newClientConfig(loadingRules, contextName).RawConfig()
+	if err != nil {
+		return ""
+	}
+	return rawConfig.CurrentContext
+}
+
+// kubeConfigFingerprint summarizes the modification times and sizes of the
+// kubeconfig files, so that changes can be detected cheaply.
+func kubeConfigFingerprint(loadingRules *clientcmd.ClientConfigLoadingRules) string {
+	var sb strings.Builder
+	for _, path := range loadingRules.GetLoadingPrecedence() {
+		if info, err := os.Stat(path); err == nil {
+			_, _ = fmt.Fprintf(&sb, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return sb.String()
+}
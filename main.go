@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -17,7 +21,11 @@ import (
 	"github.com/fatih/color"
 	"github.com/go-logr/logr"
 	"github.com/spf13/pflag"
+	"golang.org/x/text/encoding/htmlindex"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -27,32 +35,95 @@ import (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run tails until done, and returns the exit status. Exiting is left to main,
+// so that deferred cleanup runs however tailing ends.
+func run() (code int) {
 	klog.SetLogger(logr.New(&kubeLogger{}))
 
-	cfg := Config{
-		ColorMode:   "auto",
-		ColorScheme: "bw",
+	// The benchmark has its own flags, so it must come first. Being a flag,
+	// it can't be confused with a pattern
+	if len(os.Args) > 1 && os.Args[1] == "--bench" {
+		runBench(os.Args[2:])
+		return 0
 	}
 
+	cfg := defaultConfig()
+
 	var (
 		contextName       string
 		labelSelectorExpr string
+		matchExprs        []string
+		whereExpr         string
 		namespaces        []string
 		allNamespaces     bool
+		accessibleOnly    bool
+		nodes             []string
+		nodeSelectorExpr  string
 
 		kubeconfigPath        string
+		clusterConfigRef      string
+		watchKubeconfig       bool
 		quiet                 bool
 		timestamps            bool
+		lineNumbers           bool
+		shortPrefixes         bool
 		raw                   bool
 		tmplString            string
+		outputFormat          string
+		outputBuffering       string
+		flushInterval         time.Duration
+		includeLabels         []string
+		columns               []string
+		includeAnnotations    []string
 		sinceStart            bool
 		sinceExpr             string
+		fromExpr              string
+		toExpr                string
+		encodingName          string
+		keepControlChars      bool
+		bufferSizeExpr        string
+		throughputWarningExpr string
+		sampleRate            float64
+		requestTimeout        time.Duration
+		stallTimeout          time.Duration
+		kubeletFallback       bool
+		waitRunning           bool
+		backfillLoki          string
+		backfillElasticsearch string
+		bufferDir             string
+		rulesPath             string
+		redactionAudit        bool
+		redactionDryRun       bool
+		grepPatternStrings    []string
+		countMatches          bool
+		queryExpr             string
+		countInterval         time.Duration
+		histogramBucket       time.Duration
+		interactive           bool
+		sessionPath           string
+		checkPermissions      bool
+		failIfNone            bool
+		sinceAnnotations      bool
+		maxHistory            time.Duration
+		progressFormat        string
+		progressInterval      time.Duration
+		failIfNoneAfter       time.Duration
+		strictExit            bool
+		saveSessionPath       string
 		showVersion           bool
+		bench                 bool
 		includePatterns       []*regexp.Regexp
 		excludePatternStrings []string
+		excludeNamespaces     []string
+		noDefaultExclusions   bool
 		noColor               bool
 		colorMode             string
 		colorScheme           string
+		colorBy               string
+		paletteName           string
 	)
 
 	if err := cfg.LoadDefault(); err != nil {
@@ -63,34 +134,168 @@ func main() {
 	flags.SortFlags = false
 	flags.Usage = func() {
 		fmt.Printf("Usage: ktail [OPTION ...] PATTERN [PATTERN ...]\n")
+		fmt.Printf("       ktail --bench [OPTION ...]\n")
 		flags.PrintDefaults()
 	}
 	flags.StringVar(&contextName, "context", "", "Kubernetes context name")
 	flags.StringArrayVarP(&namespaces, "namespace", "n", []string{}, "Kubernetes namespace")
 	flags.BoolVar(&allNamespaces, "all-namespaces", false, "Apply to all Kubernetes namespaces")
+	flags.BoolVar(&accessibleOnly, "accessible-namespaces", false,
+		"Apply to all Kubernetes namespaces in which you are allowed to read pod logs.")
 	flags.StringArrayVarP(&excludePatternStrings, "exclude", "x", []string{},
 		"Exclude using a regular expression. Pattern can be repeated. Takes priority over"+
 			" include patterns and labels.")
+	flags.BoolVar(&noDefaultExclusions, "no-default-exclusions", cfg.NoDefaultExclusions,
+		fmt.Sprintf("Don't exclude well-known sidecar containers (%s).",
+			strings.Join(cfg.DefaultExclusions, ", ")))
+	flags.StringArrayVar(&excludeNamespaces, "exclude-namespace", []string{},
+		"Exclude a namespace by name or regular expression (must match the whole name)."+
+			" Can be repeated. Useful with --all-namespaces.")
 	flags.StringVarP(&labelSelectorExpr, "selector", "l", "",
 		"Match pods by label (see 'kubectl get -h' for syntax).")
+	flags.StringArrayVar(&matchExprs, "match", []string{},
+		"Match pods by label selector, optionally labeling their lines (e.g. 'app=checkout:payments')."+
+			" Can be repeated; pods must match at least one.")
+	flags.StringVar(&whereExpr, "where", "",
+		"Match pods by an expression of label selectors combined with AND, OR, NOT and parentheses"+
+			" (e.g. '(app=api OR app=worker) AND NOT tier=canary').")
+	flags.StringArrayVar(&nodes, "node", []string{},
+		"Only tail pods scheduled on the given node. Can be repeated.")
+	flags.StringVar(&nodeSelectorExpr, "node-selector", "",
+		"Only tail pods scheduled on nodes matching a label selector.")
+	flags.StringArrayVar(&grepPatternStrings, "grep", []string{},
+		"Only show lines whose message matches a regular expression. Can be repeated.")
+	flags.StringVar(&queryExpr, "query", "",
+		"Select containers and lines with a LogQL-style query, e.g."+
+			` '{app="checkout"} |= "timeout" | json | status >= 500'.`)
+	flags.BoolVar(&countMatches, "count", false,
+		"Instead of showing lines, periodically report the number of matching lines per container.")
+	flags.DurationVar(&histogramBucket, "histogram", 0,
+		"Instead of showing lines, periodically chart the number of matching lines per container"+
+			" in time buckets of this size (e.g. 1m).")
+	flags.DurationVar(&countInterval, "count-interval", 10*time.Second,
+		"How often to report with --count or --histogram.")
+	flags.BoolVarP(&interactive, "interactive", "i", false,
+		"Read commands from standard input to search, filter, and mute streams while tailing"+
+			" (type 'help' for a list).")
+	flags.BoolVar(&failIfNone, "fail-if-none", false,
+		fmt.Sprintf("Exit with status %d if no pods match when starting, instead of waiting for them.",
+			exitCodeNoMatches))
+	flags.DurationVar(&failIfNoneAfter, "fail-if-none-after", 0,
+		"Like --fail-if-none, but wait this long for a matching pod to appear before exiting.")
+	flags.BoolVar(&strictExit, "strict-exit", false,
+		fmt.Sprintf("Exit with status %d if the capture was incomplete, because of missing permissions,"+
+			" no matching pods, or output that couldn't be written.", exitCodeDegraded))
 	flags.BoolVarP(&sinceStart, "since-start", "s", false,
 		"Start reading log from the beginning of the container's lifetime.")
 	flags.BoolVarP(&showVersion, "version", "", false, "Show version.")
+	flags.BoolVar(&bench, "bench", false, "Benchmark against a fake cluster (must be the first argument).")
 	flags.StringVarP(&sinceExpr, "since", "S", "", "Get logs since a given time (e.g. 2023-03-30) or duration (e.g. 1h).")
+	flags.StringVar(&fromExpr, "from", "",
+		"Instead of tailing, print the logs from a given time (e.g. '2024-05-01 02:10:00') or"+
+			" duration ago (e.g. 2h), including previous runs of restarted containers, and exit.")
+	flags.StringVar(&toExpr, "to", "",
+		"With --from, print the logs until a given time or duration ago (default now).")
+	flags.DurationVar(&maxHistory, "max-history", 0,
+		"Never read logs older than this (e.g. 24h), even with --since-start or --since.")
+	flags.BoolVar(&sinceAnnotations, "since-annotations", true,
+		"Let pods override where tailing starts with the "+sinceAnnotation+" annotation.")
+
+	flags.StringVar(&encodingName, "encoding", "",
+		"Character encoding of container output, if not UTF-8 (e.g. latin1, shift_jis).")
+	flags.BoolVar(&keepControlChars, "keep-control-chars", false,
+		"Don't normalize carriage returns and control characters in messages.")
+
+	flags.DurationVar(&requestTimeout, "request-timeout", 30*time.Second,
+		"How long to wait for a log stream to open before retrying. Set to 0 to wait forever.")
+	flags.DurationVar(&stallTimeout, "stall-timeout", 0,
+		"Re-establish a log stream that has been silent this long while the container has kept logging."+
+			" Must be at least 1s; disabled by default.")
+	flags.BoolVar(&waitRunning, "wait-running", false,
+		"Attach to containers once they're running, rather than as soon as they're created,"+
+			" to avoid failed requests for logs during large rollouts.")
+	flags.BoolVar(&kubeletFallback, "kubelet-fallback", false,
+		"When getting logs through the API server keeps failing, read them from the kubelet"+
+			" through the node proxy instead (requires permission for nodes/proxy).")
+	flags.StringVar(&backfillLoki, "backfill-loki", "",
+		"Fetch history that the kubelet no longer has from Loki at this URL (e.g. http://loki:3100)"+
+			" before tailing, with --since or --since-start.")
+	flags.StringVar(&backfillElasticsearch, "backfill-elasticsearch", "",
+		"Like --backfill-loki, but search an Elasticsearch index (e.g. http://elasticsearch:9200/logs-*).")
+	flags.StringVar(&throughputWarningExpr, "throughput-warning", "10Mi",
+		"Warn when a single container logs more than this many bytes per second. Set to 0 to disable.")
+	flags.Float64Var(&sampleRate, "sample", 0,
+		"Show at most this many lines per second from each container, dropping and counting the rest.")
+	flags.StringVar(&bufferSizeExpr, "buffer-size", "",
+		"Buffer up to this much output in memory (e.g. 64Mi) when output is slower than input,"+
+			" spilling the rest to disk. By default, tailing waits for output.")
+	flags.StringVar(&bufferDir, "buffer-dir", "",
+		"Directory for buffered output that exceeds --buffer-size (default is the system temp directory).")
+
+	flags.StringVar(&rulesPath, "rules", "",
+		"Evaluate alerting rules from a YAML file against every log line.")
+
+	flags.BoolVar(&redactionAudit, "redaction-audit", false,
+		"When exiting, summarize how many lines each redaction in the config altered.")
+	flags.BoolVar(&redactionDryRun, "redaction-dry-run", false,
+		"Don't alter lines with redactions, but summarize what they would have altered when exiting.")
+
+	flags.StringVar(&sessionPath, "session", "",
+		"Load patterns and flags from a session file saved with --save-session. Flags given on"+
+			" the command line take priority.")
+	flags.StringVar(&saveSessionPath, "save-session", "",
+		"Save the patterns and the effective value of every flag to a file, so the same tail can"+
+			" be reproduced with --session.")
+
+	flags.StringVar(&progressFormat, "progress", "",
+		"Periodically write the status of tailing to standard error. The only format is 'json'.")
+	flags.DurationVar(&progressInterval, "progress-interval", 5*time.Second,
+		"How often to write the status with --progress.")
 
 	flags.StringVar(&kubeconfigPath, "kubeconfig", cfg.KubeConfigPath,
 		"Path to kubeconfig (only required out-of-cluster)")
+	flags.BoolVar(&checkPermissions, "check-permissions", false,
+		"Before tailing, check whether pods can be listed, watched and read in each namespace,"+
+			" and report the permissions in each.")
+	flags.BoolVar(&watchKubeconfig, "watch-kubeconfig", false,
+		"Reconnect when the kubeconfig changes, such as after a credential refresh or context switch.")
+	flags.StringVar(&clusterConfigRef, "cluster-config", cfg.ClusterConfig,
+		"Read shared defaults from this config map (namespace/name), such as"+
+			" kube-public/ktail-config. Local config and flags take priority.")
+	flags.StringVarP(&outputFormat, "output", "o", cfg.Output,
+		"Output format: one of 'text' (default), 'json', 'logfmt', 'csv', 'tsv', or 'proto'.")
+	flags.StringVar(&outputBuffering, "output-buffering", "auto",
+		"Buffering of standard output: 'line' writes every line immediately, 'block' buffers"+
+			" output and flushes it every --flush-interval, and 'auto' (default) picks 'line' for"+
+			" terminals and 'block' otherwise.")
+	flags.DurationVar(&flushInterval, "flush-interval", 200*time.Millisecond,
+		"How often to flush block-buffered output.")
+	flags.StringSliceVar(&columns, "columns", cfg.Columns,
+		"Comma-separated columns for CSV/TSV output: ts, ns, pod, container, line, msg, match,"+
+			" label.NAME, annotation.NAME, field.NAME (default ts,ns,pod,container,msg).")
+	flags.StringSliceVar(&includeLabels, "include-labels", cfg.IncludeLabels,
+		"Comma-separated pod labels to include on each event in structured output ('*' for all).")
+	flags.StringSliceVar(&includeAnnotations, "include-annotations", cfg.IncludeAnnotations,
+		"Comma-separated pod annotations to include on each event in structured output ('*' for all).")
 	flags.StringVarP(&tmplString, "template", "t", cfg.TemplateString,
 		"Template to format each line. For example, for"+
 			" just the message, use --template '{{ .Message }}'.")
 	flags.BoolVarP(&raw, "raw", "r", cfg.Raw, "Don't format output; output messages only (unless --timestamps)")
 	flags.BoolVarP(&timestamps, "timestamps", "T", cfg.Timestamps, "Include timestamps on each line")
+	flags.BoolVar(&lineNumbers, "line-numbers", cfg.LineNumbers, "Prefix each line with its line number within the container's stream")
+	flags.BoolVar(&shortPrefixes, "short-prefixes", cfg.ShortPrefixes,
+		"Replace the pod and container prefix of each line with a short alias, printing a legend as containers appear")
 	flags.BoolVarP(&quiet, "quiet", "q", cfg.Quiet, "Don't print events about new/deleted pods")
 	flags.BoolVar(&noColor, "no-color", cfg.NoColor, "Alias for --color=never.")
 	flags.StringVar(&colorMode, "color", cfg.ColorMode, "Set color mode: one of 'auto' (default), 'never', or 'always'. (Aliased as --colour.)")
 	flags.StringVar(&colorMode, "colour", cfg.ColorMode, "Set color mode: one of 'auto' (default), 'never', or 'always'.")
 	flags.StringVar(&colorScheme, "color-scheme", cfg.ColorScheme, "Set color scheme (see https://github.com/alecthomas/chroma/tree/master/styles). (Aliased as --colour-scheme.)")
 	flags.StringVar(&colorScheme, "colour-scheme", cfg.ColorScheme, "Set color scheme (see https://github.com/alecthomas/chroma/tree/master/styles).")
+	flags.StringVar(&colorBy, "color-by", cfg.ColorBy,
+		"Assign colors by 'stream' (default), 'pod', 'namespace', 'container' (name, shared"+
+			" across replicas), or 'label:NAME' (e.g. label:app).")
+	flags.StringVar(&paletteName, "palette", cfg.Palette,
+		"Color palette to assign to streams: one of '8' (default), '256', or 'truecolor'.")
 	_ = flags.MarkHidden("colour")
 	_ = flags.MarkHidden("colour-scheme")
 
@@ -101,6 +306,60 @@ func main() {
 		fail(err.Error())
 	}
 
+	patternArgs := flags.Args()
+	if sessionPath != "" {
+		sessionPatterns, err := LoadSession(sessionPath, flags)
+		if err != nil {
+			fail(err.Error())
+		}
+		if len(patternArgs) == 0 {
+			patternArgs = sessionPatterns
+		}
+	}
+
+	if bench {
+		fail("--bench must be the first argument")
+	}
+
+	if showVersion {
+		fmt.Printf("ktail %s\n", version)
+		os.Exit(0)
+	}
+
+	var loadingRules *clientcmd.ClientConfigLoadingRules
+	if kubeconfigPath != "" {
+		loadingRules = &clientcmd.ClientConfigLoadingRules{
+			ExplicitPath: kubeconfigPath,
+		}
+	} else {
+		loadingRules = clientcmd.NewDefaultClientConfigLoadingRules()
+	}
+
+	clientConfig := newClientConfig(loadingRules, contextName)
+	config, clientset, err := newClientset(clientConfig)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	if clusterConfigRef != "" {
+		// Cluster defaults have the lowest priority, so the local config file is
+		// loaded on top of them, and flags given explicitly are left alone.
+		clusterCfg := defaultConfig()
+		if err := clusterCfg.LoadFromConfigMap(context.Background(), clientset, clusterConfigRef); err != nil {
+			fail(err.Error())
+		}
+		if err := clusterCfg.LoadDefaultOver(); err != nil {
+			fail(err.Error())
+		}
+		cfg = clusterCfg
+		if err := cfg.ApplyToFlags(flags); err != nil {
+			fail(err.Error())
+		}
+	}
+
+	excludePatternStrings = append(cfg.Exclude, excludePatternStrings...)
+	excludeNamespaces = append(cfg.ExcludeNamespaces, excludeNamespaces...)
+
 	if noColor {
 		colorMode = "never"
 	}
@@ -115,11 +374,55 @@ func main() {
 
 	color.NoColor = !colorEnabled
 
-	if showVersion {
-		fmt.Printf("ktail %s\n", version)
-		os.Exit(0)
+	// Registered first, so that it runs after everything else has been
+	// flushed and closed
+	var health healthTracker
+	var discovered atomic.Bool
+	defer func() {
+		if !strictExit {
+			return
+		}
+		if !discovered.Load() {
+			health.report("no matching pods were found")
+		}
+		if problems := health.Problems(); len(problems) > 0 {
+			printError("Capture is incomplete:\n    %s", strings.Join(problems, "\n    "))
+			if code == 0 {
+				code = exitCodeDegraded
+			}
+		}
+	}()
+
+	lineBuffered, err := parseOutputBuffering(outputBuffering, isTerminal(os.Stdout))
+	if err != nil {
+		fail("invalid --output-buffering flag: %s", err)
+	}
+	if !lineBuffered && flushInterval <= 0 {
+		fail("invalid --flush-interval flag: must be positive")
 	}
 
+	// Output goes through console writers, so that colors work in Windows
+	// consoles
+	var console io.Writer = os.Stdout
+	if colorEnabled {
+		console = consoleWriter(os.Stdout)
+		stderr = consoleWriter(os.Stderr)
+	}
+	stdout := newFlushWriter(console, lineBuffered)
+	defer func() {
+		_ = stdout.Flush()
+	}()
+
+	colorKey, err := parseColorKey(colorBy)
+	if err != nil {
+		fail("invalid --color-by flag: %s", err)
+	}
+	palette, err := newPalette(paletteName)
+	if err != nil {
+		fail("invalid --palette flag: %s", err)
+	}
+	colors := newColorAssigner(palette)
+
 	var excludePatterns []*regexp.Regexp
 	for _, p := range excludePatternStrings {
 		r, err := regexp.Compile(p)
@@ -129,7 +432,16 @@ func main() {
 		excludePatterns = append(excludePatterns, r)
 	}
 
-	for _, arg := range flags.Args() {
+	var excludeNamespacePatterns []*regexp.Regexp
+	for _, p := range excludeNamespaces {
+		r, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			fail("Invalid regexp: %q: %s\n", p, err)
+		}
+		excludeNamespacePatterns = append(excludeNamespacePatterns, r)
+	}
+
+	for _, arg := range patternArgs {
 		r, err := regexp.Compile(arg)
 		if err != nil {
 			fail("Invalid regexp: %q: %s\n", arg, err)
@@ -137,6 +449,41 @@ func main() {
 		includePatterns = append(includePatterns, r)
 	}
 
+	var grepMatcher Matcher
+	if len(grepPatternStrings) > 0 {
+		var grepPatterns []*regexp.Regexp
+		for _, p := range grepPatternStrings {
+			r, err := regexp.Compile(p)
+			if err != nil {
+				fail("Invalid regexp: %q: %s\n", p, err)
+			}
+			grepPatterns = append(grepPatterns, r)
+		}
+		grepMatcher = buildMessageMatcher(grepPatterns)
+	}
+	var query *Query
+	if queryExpr != "" {
+		query, err = ParseQuery(queryExpr)
+		if err != nil {
+			fail("invalid --query flag: %s", err)
+		}
+		if query.Lines != nil {
+			grepMatcher = buildAnd(grepMatcher, query.Lines)
+		}
+	}
+	if (countMatches || histogramBucket != 0) && countInterval <= 0 {
+		fail("invalid --count-interval flag: must be positive")
+	}
+	if histogramBucket < 0 {
+		fail("invalid --histogram flag: must be positive")
+	}
+	if countMatches && histogramBucket > 0 {
+		fail("--count and --histogram can't be used together")
+	}
+	if failIfNone && failIfNoneAfter > 0 {
+		fail("--fail-if-none and --fail-if-none-after can't be used together")
+	}
+
 	labelSelector := labels.Everything()
 	if labelSelectorExpr != "" {
 		if sel, err := labels.Parse(labelSelectorExpr); err != nil {
@@ -146,36 +493,50 @@ func main() {
 		}
 	}
 
-	inclusionMatcher := buildMatcher(includePatterns, labelSelector, true)
-	exclusionMatcher := buildMatcher(excludePatterns, nil, false)
-
-	var loadingRules *clientcmd.ClientConfigLoadingRules
-	if kubeconfigPath != "" {
-		loadingRules = &clientcmd.ClientConfigLoadingRules{
-			ExplicitPath: kubeconfigPath,
+	var matchLabels []LabeledMatcher
+	for _, expr := range matchExprs {
+		m, err := parseLabeledMatcher(expr)
+		if err != nil {
+			fail("invalid --match flag: %s", err)
 		}
-	} else {
-		loadingRules = clientcmd.NewDefaultClientConfigLoadingRules()
+		matchLabels = append(matchLabels, m)
 	}
 
-	clientConfig := clientcmd.NewInteractiveDeferredLoadingClientConfig(loadingRules,
-		&clientcmd.ConfigOverrides{
-			CurrentContext: contextName,
-		},
-		nil)
-
-	config, err := clientConfig.ClientConfig()
-	if err != nil {
-		fail(err.Error())
+	inclusionMatcher := buildMatcher(includePatterns, labelSelector, true)
+	if len(matchLabels) > 0 {
+		inclusionMatcher = and{buildLabeledMatcher(matchLabels), inclusionMatcher}
+	}
+	exclusionMatcher := buildMatcher(excludePatterns, nil, false)
+	if len(excludeNamespacePatterns) > 0 {
+		exclusionMatcher = or{exclusionMatcher, buildNamespaceMatcher(excludeNamespacePatterns)}
+	}
+	if !noDefaultExclusions && len(cfg.DefaultExclusions) > 0 {
+		exclusionMatcher = or{exclusionMatcher, buildContainerNameMatcher(cfg.DefaultExclusions)}
+	}
+	if query != nil {
+		exclusionMatcher = or{exclusionMatcher, query.Exclusion}
+	}
+	if whereExpr != "" {
+		where, err := ParseSelectorExpression(whereExpr)
+		if err != nil {
+			fail("invalid --where flag: %s", err)
+		}
+		exclusionMatcher = or{exclusionMatcher, podMatcher{not{where}}}
 	}
 
-	// Set higher rate limits
-	config.QPS = 100
-	config.Burst = 100
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		fail(err.Error())
+	if nodeSelectorExpr != "" {
+		nodeList, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{
+			LabelSelector: nodeSelectorExpr,
+		})
+		if err != nil {
+			fail("listing nodes: %s", err)
+		}
+		if len(nodeList.Items) == 0 {
+			fail("no nodes match selector %q", nodeSelectorExpr)
+		}
+		for _, node := range nodeList.Items {
+			nodes = append(nodes, node.Name)
+		}
 	}
 
 	rawConfig, err := clientConfig.RawConfig()
@@ -183,7 +544,21 @@ func main() {
 		fail(err.Error())
 	}
 
-	if allNamespaces {
+	if accessibleOnly {
+		if allNamespaces || len(namespaces) > 0 {
+			fail("--accessible-namespaces can't be combined with --all-namespaces or --namespace")
+		}
+		namespaces, err = accessibleNamespaces(context.Background(), clientset)
+		if err != nil {
+			fail(err.Error())
+		}
+		if len(namespaces) == 0 {
+			fail("there are no namespaces in which you are allowed to read pod logs")
+		}
+		if !quiet {
+			printInfo("Tailing %d accessible namespaces: %s", len(namespaces), strings.Join(namespaces, ", "))
+		}
+	} else if allNamespaces {
 		namespaces = []string{v1.NamespaceAll}
 	} else if len(namespaces) == 0 {
 		if rawConfig.Contexts[rawConfig.CurrentContext].Namespace == "" {
@@ -193,6 +568,26 @@ func main() {
 		}
 	}
 
+	if maxHistory < 0 {
+		fail("invalid --max-history flag: must be positive")
+	}
+	if stallTimeout < 0 || (stallTimeout > 0 && stallTimeout < minStallTimeout) {
+		fail("invalid --stall-timeout flag: must be at least %s", minStallTimeout)
+	}
+
+	switch progressFormat {
+	case "", "json":
+	default:
+		fail("invalid --progress flag: unknown format %q", progressFormat)
+	}
+	if progressFormat != "" && progressInterval <= 0 {
+		fail("invalid --progress-interval flag: must be positive")
+	}
+
+	if err := validateOutputFormat(outputFormat); err != nil {
+		fail("invalid --output flag: %s", err)
+	}
+
 	var tmpl *template.Template
 	if tmplString != "" {
 		var err error
@@ -207,6 +602,79 @@ func main() {
 		fail("invalid --since flag: %s", err)
 	}
 
+	captureFrom, err := parseSinceExpr(fromExpr)
+	if err != nil {
+		fail("invalid --from flag: %s", err)
+	}
+	captureTo := time.Now()
+	if toExpr != "" {
+		if captureFrom == nil {
+			fail("--to requires --from")
+		}
+		t, err := parseSinceExpr(toExpr)
+		if err != nil {
+			fail("invalid --to flag: %s", err)
+		}
+		captureTo = *t
+	}
+	if captureFrom != nil {
+		if !captureFrom.Before(captureTo) {
+			fail("--from must be before --to")
+		}
+		for _, name := range []string{"since", "since-start", "interactive", "count", "histogram", "buffer-size"} {
+			if flags.Changed(name) {
+				fail("--from can't be combined with --%s", name)
+			}
+		}
+	}
+
+	var bufferSize resource.Quantity
+	if bufferSizeExpr != "" {
+		bufferSize, err = resource.ParseQuantity(bufferSizeExpr)
+		if err != nil {
+			fail("invalid --buffer-size flag: %s", err)
+		}
+	}
+
+	throughputWarning, err := resource.ParseQuantity(throughputWarningExpr)
+	if err != nil {
+		fail("invalid --throughput-warning flag: %s", err)
+	}
+
+	if sampleRate < 0 {
+		fail("invalid --sample flag: must be positive")
+	}
+
+	tailerOptions := TailerOptions{
+		RateLimit:         sampleRate,
+		KeepControlChars:  keepControlChars,
+		ThroughputWarning: throughputWarning.Value(),
+		RequestTimeout:    requestTimeout,
+		StallTimeout:      stallTimeout,
+		KubeletFallback:   kubeletFallback,
+	}
+	switch {
+	case backfillLoki != "" && backfillElasticsearch != "":
+		fail("--backfill-loki and --backfill-elasticsearch can't be used together")
+	case backfillLoki != "":
+		tailerOptions.Backfill, err = NewBackfillSource("loki", backfillLoki)
+		if err != nil {
+			fail("invalid --backfill-loki flag: %s", err)
+		}
+	case backfillElasticsearch != "":
+		tailerOptions.Backfill, err = NewBackfillSource("elasticsearch", backfillElasticsearch)
+		if err != nil {
+			fail("invalid --backfill-elasticsearch flag: %s", err)
+		}
+	}
+	if encodingName != "" {
+		enc, err := htmlindex.Get(encodingName)
+		if err != nil {
+			fail("invalid --encoding flag: %s", err)
+		}
+		tailerOptions.Encoding = enc
+	}
+
 	formatPod := func(pod *v1.Pod) string {
 		if allNamespaces || len(namespaces) > 1 {
 			return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
@@ -218,33 +686,80 @@ func main() {
 		return fmt.Sprintf("%s:%s", formatPod(pod), container.Name)
 	}
 
+	// linePrefix identifies the stream of each line of text output
+	linePrefix := func(pod *v1.Pod, container *v1.Container) string {
+		prefix := fmt.Sprintf("%s:%s", pod.Name, container.Name)
+		if allNamespaces {
+			prefix = pod.Namespace + "/" + prefix
+		}
+		return prefix
+	}
+
+	var session *sessionFilter
+	if interactive {
+		session = &sessionFilter{}
+	}
+
 	var printEvent func(*LogEvent) error
+	var aliases *prefixAliases
 
-	if tmpl != nil {
+	switch {
+	case outputFormat == "json":
+		printEvent = newJSONPrinter(stdout, podMetadata{
+			labels:      includeLabels,
+			annotations: includeAnnotations,
+		})
+	case outputFormat == "logfmt":
+		printEvent = newLogfmtPrinter(stdout, podMetadata{
+			labels:      includeLabels,
+			annotations: includeAnnotations,
+		})
+	case outputFormat == "proto":
+		printEvent = newProtoPrinter(stdout, podMetadata{
+			labels:      includeLabels,
+			annotations: includeAnnotations,
+		})
+	case outputFormat == "csv" || outputFormat == "tsv":
+		separator := ','
+		if outputFormat == "tsv" {
+			separator = '\t'
+		}
+		printEvent, err = newCSVPrinter(stdout, columns, separator, true)
+		if err != nil {
+			fail("invalid --columns flag: %s", err)
+		}
+	case tmpl != nil:
 		printEvent = func(event *LogEvent) error {
 			type templateEvent struct {
-				Pod       *v1.Pod
-				Container *v1.Container
-				Timestamp string
-				Message   string
+				Pod        *v1.Pod
+				Container  *v1.Container
+				Timestamp  string
+				Message    string
+				LineNumber int64
+				MatchLabel string
 			}
 
 			var buf bytes.Buffer
 			if err := tmpl.Execute(&buf, &templateEvent{
-				Pod:       event.Pod,
-				Container: event.Container,
-				Message:   event.Message,
-				Timestamp: formatTimestamp(event.Timestamp),
+				Pod:        event.Pod,
+				Container:  event.Container,
+				Message:    event.Message,
+				Timestamp:  formatTimestamp(event.Timestamp),
+				LineNumber: event.LineNumber,
+				MatchLabel: event.MatchLabel,
 			}); err != nil {
 				return err
 			}
 
-			_, err := fmt.Fprintln(os.Stdout, buf.String())
+			_, err := fmt.Fprintln(stdout, buf.String())
 			return err
 		}
-	} else {
+	default:
+		if shortPrefixes {
+			aliases = newPrefixAliases()
+		}
 		printEvent = func(event *LogEvent) error {
-			col := getColorConfig(event.Pod.Name, event.Container.Name)
+			col := colors.get(colorKey(event.Pod, event.Container)...)
 
 			var line string
 			if !raw {
@@ -252,16 +767,33 @@ func main() {
 					line = col.metadata.Sprint(formatTimestamp(event.Timestamp))
 					line += " "
 				}
-				if allNamespaces {
-					line += col.labels.Sprint(fmt.Sprintf("%s/%s:%s",
-						event.Pod.Namespace, event.Pod.Name, event.Container.Name))
-				} else {
-					line += col.labels.Sprint(fmt.Sprintf("%s:%s", event.Pod.Name, event.Container.Name))
+				if lineNumbers {
+					line += col.metadata.Sprint(fmt.Sprintf("%6d", event.LineNumber))
+					line += " "
+				}
+				if event.MatchLabel != "" {
+					line += col.metadata.Sprint("[" + event.MatchLabel + "]")
+					line += " "
+				}
+				prefix := linePrefix(event.Pod, event.Container)
+				if aliases != nil {
+					alias, legend := aliases.get(prefix, colorKey(event.Pod, event.Container))
+					for _, entry := range legend {
+						if _, err := fmt.Fprintln(stdout, colors.get(entry.colorKey...).labels.Sprint(
+							fmt.Sprintf("%s = %s", entry.alias, entry.prefix))); err != nil {
+							return err
+						}
+					}
+					prefix = alias
 				}
+				line += col.labels.Sprint(prefix)
 				line += " "
 			}
 
 			payload := event.Message
+			if session != nil {
+				payload = session.highlight(payload)
+			}
 			if colorEnabled && len(payload) >= 2 && payload[0] == '{' && payload[len(payload)-1] == '}' {
 				var dest interface{}
 				if err := json.Unmarshal([]byte(payload), &dest); err == nil {
@@ -274,33 +806,278 @@ func main() {
 
 			line += payload
 
-			_, err := fmt.Fprintln(os.Stdout, line)
+			_, err := fmt.Fprintln(stdout, line)
 			return err
 		}
 	}
 
+	var rules *RuleSet
+	if rulesPath != "" {
+		rules, err = LoadRules(rulesPath)
+		if err != nil {
+			fail(err.Error())
+		}
+	}
+
+	overrides, err := newTailerOverrides(cfg.Overrides)
+	if err != nil {
+		fail("invalid overrides in config: %s", err)
+	}
+
+	var redactions *redactor
+	if len(cfg.Redactions) > 0 {
+		redactions, err = newRedactor(cfg.Redactions, redactionDryRun)
+		if err != nil {
+			fail("invalid redactions in config: %s", err)
+		}
+		if redactionAudit || redactionDryRun {
+			defer func() {
+				printInfo("Redaction audit:")
+				_ = redactions.report(stderr)
+			}()
+		}
+	} else if redactionAudit || redactionDryRun {
+		fail("--redaction-audit and --redaction-dry-run require redactions in the config")
+	}
+
+	// Redactions are applied before events are buffered, so that nothing is
+	// written to disk unredacted
+	var middleware []LogEventMiddleware
+	if redactions != nil {
+		middleware = append(middleware, redactions.redact)
+	}
+
+	var routes *router
+	if len(cfg.Routes) > 0 {
+		routes, err = newRouter(cfg.Routes, podMetadata{
+			labels:      includeLabels,
+			annotations: includeAnnotations,
+		})
+		if err != nil {
+			fail("invalid routes in config: %s", err)
+		}
+		defer func() {
+			if err := routes.Close(); err != nil {
+				printError(fmt.Sprintf("Could not close routed output: %s", err))
+				health.report("could not close routed output: %s", err)
+			}
+		}()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Stop cleanly on Ctrl+C (or Ctrl+Break on Windows), so that routed output
+	// is flushed and closed. A second interrupt exits immediately.
+	signalCtx, stopSignals := signal.NotifyContext(ctx, shutdownSignals...)
+	go func() {
+		<-signalCtx.Done()
+		stopSignals()
+		cancel()
+	}()
+
+	// exitWith stops tailing, making ktail exit with a status. The first
+	// status wins.
+	var exitCode atomic.Int32
+	exitWith := func(code int) {
+		exitCode.CompareAndSwap(0, int32(code))
+		cancel()
+	}
+	if rules != nil {
+		rules.OnExit = exitWith
+	}
+
+	// failOutput reports output that couldn't be written, and stops tailing.
+	// Only the first failure is reported, since once output is broken, every
+	// event that's still buffered would fail the same way.
+	var outputFailed atomic.Bool
+	failOutput := func(what string, err error) {
+		if !outputFailed.CompareAndSwap(false, true) {
+			return
+		}
+		printError(fmt.Sprintf("Could not %s: %s", what, err))
+		health.report("could not %s: %s", what, err)
+		cancel()
+	}
+
+	if !lineBuffered {
+		go func() {
+			if err := stdout.Run(ctx, flushInterval); err != nil && !errors.Is(err, context.Canceled) {
+				failOutput("write output", err)
+			}
+		}()
+	}
+
+	var counter *matchCounter
+	if countMatches {
+		counter = newMatchCounter()
+		go func() {
+			if err := counter.Run(ctx, countInterval, stdout); err != nil && !errors.Is(err, context.Canceled) {
+				failOutput("write counts", err)
+			}
+		}()
+	}
+
+	var hist *histogram
+	if histogramBucket > 0 {
+		hist = newHistogram(histogramBucket)
+		go func() {
+			if err := hist.Run(ctx, countInterval, stdout); err != nil && !errors.Is(err, context.Canceled) {
+				failOutput("write histogram", err)
+			}
+		}()
+	}
+
 	var stdoutMutex sync.Mutex
+	var emitted atomic.Int64
+	onEvent := func(event LogEvent) {
+		// Rules see every line, whatever is shown
+		if rules != nil {
+			rules.Evaluate(&event)
+		}
+		if grepMatcher != nil && !grepMatcher.Match(&event) {
+			return
+		}
+		if session != nil && !session.allow(formatPodAndContainer(event.Pod, event.Container), &event) {
+			return
+		}
+		routed := false
+		if routes != nil {
+			var err error
+			if routed, err = routes.route(&event); err != nil {
+				failOutput("write event", err)
+			}
+		}
+		emitted.Add(1)
+		switch {
+		case routed:
+		case counter != nil:
+			counter.add(formatPodAndContainer(event.Pod, event.Container))
+		case hist != nil:
+			hist.add(formatPodAndContainer(event.Pod, event.Container), *event.Timestamp)
+		default:
+			stdoutMutex.Lock()
+			err := printEvent(&event)
+			stdoutMutex.Unlock()
+			if err != nil {
+				failOutput("write event", err)
+			}
+		}
+	}
+
+	printMarker := newMarkerPrinter(stdout, outputFormat)
+	// writeMarker writes a bookmark to the output, and to routed files
+	writeMarker := func(now time.Time, note string) {
+		if routes != nil {
+			if err := routes.mark(now, note); err != nil {
+				printError(fmt.Sprintf("Could not write marker: %s", err))
+			}
+		}
+		if printMarker == nil || counter != nil || hist != nil {
+			printInfo("---- MARK %s %s----", formatTimestamp(&now), note+" ")
+			return
+		}
+		stdoutMutex.Lock()
+		err := printMarker(now, note)
+		stdoutMutex.Unlock()
+		if err != nil {
+			printError(fmt.Sprintf("Could not write marker: %s", err))
+		}
+	}
+	insertMarker := func(note string) {
+		writeMarker(time.Now(), note)
+	}
+
+	if bufferSize.Value() > 0 {
+		buffer := NewEventBuffer(bufferSize.Value(), bufferDir, func(path string) {
+			printInfo("Output is falling behind; buffering events on disk in %s", path)
+		})
+		consume := onEvent
+		bufferDone := make(chan struct{})
+		go func() {
+			defer close(bufferDone)
+			err := buffer.Run(ctx, func(event LogEvent) {
+				if event.Marker {
+					writeMarker(*event.Timestamp, event.Message)
+					return
+				}
+				consume(event)
+			})
+			if err != nil && !errors.Is(err, context.Canceled) {
+				failOutput("buffer output", err)
+			}
+		}()
+		// Registered after the output, so that what's still buffered is
+		// written out before the output is flushed and closed
+		defer func() {
+			<-bufferDone
+		}()
+		onEvent = func(event LogEvent) {
+			if err := buffer.Push(event); err != nil {
+				printError(fmt.Sprintf("Could not buffer event: %s", err))
+				health.report("could not buffer events: %s", err)
+			}
+		}
+		// Markers are buffered too, so that they're written after the lines
+		// that came before them
+		insertMarker = func(note string) {
+			now := time.Now()
+			if err := buffer.Push(LogEvent{Timestamp: &now, Message: note, Marker: true}); err != nil {
+				printError(fmt.Sprintf("Could not write marker: %s", err))
+			}
+		}
+	}
+
+	if saveSessionPath != "" {
+		if accessibleOnly {
+			// The namespaces that were found are saved instead, and the two
+			// can't be combined
+			_ = flags.Lookup("accessible-namespaces").Value.Set("false")
+		}
+		if err := SaveSession(saveSessionPath, flags, patternArgs); err != nil {
+			fail(err.Error())
+		}
+		printInfo("Saved session to %s", saveSessionPath)
+	}
+
+	if len(markSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, markSignals...)
+		go func() {
+			for range signals {
+				insertMarker("")
+			}
+		}()
+	}
+
 	controller := NewController(clientset,
 		ControllerOptions{
 			Namespaces:       namespaces,
+			Nodes:            nodes,
 			InclusionMatcher: inclusionMatcher,
 			ExclusionMatcher: exclusionMatcher,
 			Since:            since,
 			SinceStart:       sinceStart,
+			Tailer:           tailerOptions,
+			SinceAnnotations: sinceAnnotations,
+			MatchLabels:      matchLabels,
+			TailerOverrides:  overrides,
+			WaitForRunning:   waitRunning,
+			MaxHistory:       maxHistory,
+			NewClient: func() (kubernetes.Interface, error) {
+				_, client, err := newClientset(newClientConfig(loadingRules, contextName))
+				return client, err
+			},
 		},
 		Callbacks{
-			OnEvent: func(event LogEvent) {
-				stdoutMutex.Lock()
-				defer stdoutMutex.Unlock()
-				if err := printEvent(&event); err != nil {
-					printError(fmt.Sprintf("Could not write event: %s", err))
-					cancel()
-				}
-			},
+			OnEvent:    onEvent,
+			Middleware: middleware,
 			OnEnter: func(pod *v1.Pod, container *v1.Container, initialAddPhase bool) bool {
+				discovered.Store(true)
+				colors.acquire(colorKey(pod, container)...)
+				if counter != nil {
+					counter.track(formatPodAndContainer(pod, container))
+				}
 				if !quiet {
 					if initialAddPhase {
 						printInfo("Attached to container [%s]", formatPodAndContainer(pod, container))
@@ -311,36 +1088,151 @@ func main() {
 				return true
 			},
 			OnExit: func(pod *v1.Pod, container *v1.Container) {
+				colors.release(colorKey(pod, container)...)
+				if aliases != nil {
+					aliases.remove(linePrefix(pod, container))
+				}
 				if !quiet {
-					var status = "unknown"
-					for _, containerStatus := range pod.Status.ContainerStatuses {
-						if containerStatus.Name == container.Name {
-							if containerStatus.State.Running != nil {
-								status = "running"
-							} else if containerStatus.State.Waiting != nil {
-								status = "waiting"
-							} else if containerStatus.State.Terminated != nil {
-								status = "terminated"
-							}
-							break
-						}
+					status := describeContainerState(pod, container)
+					if status == "" {
+						status = "unknown"
+					}
+					if reason := podDisruptionReason(pod); reason != "" {
+						status = "disrupted: " + reason + ", " + status
 					}
 					printInfo(fmt.Sprintf("Container left (%s) [%s]", status,
 						formatPodAndContainer(pod, container)))
 				}
 			},
+			OnInitContainer: func(pod *v1.Pod, container *v1.Container, step, steps int) {
+				if !quiet {
+					printInfo("---- Init container %d/%d [%s] ----", step, steps,
+						formatPodAndContainer(pod, container))
+				}
+			},
+			OnInitialized: func(pod *v1.Pod) {
+				if !quiet {
+					printInfo("---- Init complete; starting containers [%s] ----", formatPod(pod))
+				}
+			},
 			OnNothingDiscovered: func() {
-				printInfo("No matching pods running yet")
+				switch {
+				case failIfNoneAfter > 0:
+					printInfo("No matching pods running yet; waiting %s", failIfNoneAfter)
+					time.AfterFunc(failIfNoneAfter, func() {
+						if !discovered.Load() {
+							printError("No matching pods appeared within %s", failIfNoneAfter)
+							exitWith(exitCodeNoMatches)
+						}
+					})
+				case failIfNone:
+					printError("No matching pods running")
+					exitWith(exitCodeNoMatches)
+				default:
+					printInfo("No matching pods running yet")
+				}
+			},
+			OnNamespaceMissing: func(namespace string) {
+				printInfo("Namespace %q does not exist yet; waiting for it to be created", namespace)
+			},
+			OnNamespaceCreated: func(namespace string) {
+				if !quiet {
+					printInfo("Namespace %q was created", namespace)
+				}
+			},
+			OnKubeletFallback: func(pod *v1.Pod, container *v1.Container, err error) {
+				printInfo("Reading logs through the kubelet after repeated API server errors (%s) [%s]",
+					err, formatPodAndContainer(pod, container))
+			},
+			OnWaiting: func(pod *v1.Pod, container *v1.Container, reason string) {
+				if !quiet {
+					printInfo("Waiting for container to start (%s) [%s]", reason,
+						formatPodAndContainer(pod, container))
+				}
+			},
+			OnRateLimited: func(pod *v1.Pod, container *v1.Container, dropped int64) {
+				printError("Dropped %d lines over the rate limit [%s]", dropped,
+					formatPodAndContainer(pod, container))
+			},
+			OnHighThroughput: func(pod *v1.Pod, container *v1.Container, bytesPerSecond float64) {
+				printError(fmt.Sprintf("Container [%s] is logging %.1f MiB/s. To reduce output,"+
+					" consider a more specific pattern, --grep, --sample 100, or --exclude '^%s$'",
+					formatPodAndContainer(pod, container), bytesPerSecond/(1024*1024),
+					regexp.QuoteMeta(container.Name)))
 			},
 			OnError: func(pod *v1.Pod, container *v1.Container, err error) {
-				printError(fmt.Sprintf("Error while tailing container [%s]: %s",
-					formatPodAndContainer(pod, container), err))
+				message := fmt.Sprintf("Error while tailing container [%s]: %s",
+					formatPodAndContainer(pod, container), err)
+				if status := describeContainerState(pod, container); status != "" {
+					message += fmt.Sprintf(" (container is %s)", status)
+				}
+				printError(message)
+				if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+					health.report("not allowed to read logs of container [%s]",
+						formatPodAndContainer(pod, container))
+				}
 			},
 		})
 
+	// Overlapping namespaces are resolved by the controller, so that names are
+	// formatted, and permissions checked, for the namespaces actually tailed
+	namespaces = controller.Namespaces
+
+	if checkPermissions && !accessibleOnly {
+		printInfo("Permissions:")
+		if !checkTailPermissions(context.Background(), clientset, namespaces, stderr) {
+			health.report("missing permissions to tail pods")
+			printInfo("Pods can't be tailed where permissions are missing until access is granted")
+		}
+	}
+
+	if captureFrom != nil {
+		if err := controller.Capture(ctx, *captureFrom, captureTo); err != nil && !errors.Is(err, context.Canceled) {
+			printError(err.Error())
+			health.report("%s", err)
+		}
+		return int(exitCode.Load())
+	}
+
+	if progressFormat != "" {
+		go func() {
+			if err := reportProgress(ctx, os.Stderr, progressInterval, controller, emitted.Load); err != nil &&
+				!errors.Is(err, context.Canceled) {
+				printError(fmt.Sprintf("Could not write progress: %s", err))
+			}
+		}()
+	}
+
+	if session != nil {
+		session.onMark = insertMarker
+		session.controller = controller
+		go func() {
+			if err := session.Run(os.Stdin); err != nil {
+				printError(fmt.Sprintf("Could not read commands: %s", err))
+			}
+		}()
+	}
+
+	if watchKubeconfig {
+		go watchKubeConfig(ctx, loadingRules, contextName, config,
+			func(client kubernetes.Interface, contextName string, host string, clusterChanged bool) {
+				if !clusterChanged {
+					controller.UpdateCredentials(client)
+					return
+				}
+				printInfo("Kubeconfig changed; reconnecting to context %q (%s)", contextName, host)
+				controller.SetClient(client)
+			},
+			func(err error) {
+				printError(err.Error())
+			})
+	}
+
 	if err := controller.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		printError(err.Error())
+		health.report("%s", err)
 	}
+	return int(exitCode.Load())
 }
 
 func fail(format string, args ...interface{}) {
@@ -370,6 +1262,10 @@ func parseSinceExpr(s string) (*time.Time, error) {
 	return &t, nil
 }
 
+// exitCodeNoMatches is the exit status with --fail-if-none when no pods
+// match.
+const exitCodeNoMatches = 3
+
 var (
 	colorInfo  = color.New(color.FgYellow).SprintFunc()
 	colorError = color.New(color.FgRed).SprintFunc()
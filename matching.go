@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -66,6 +68,43 @@ func (m regexMatcher) Match(value interface{}) bool {
 	return false
 }
 
+type containerNameMatcher struct {
+	name string
+}
+
+func (m containerNameMatcher) Match(value interface{}) bool {
+	switch t := value.(type) {
+	case *v1.Container:
+		return t.Name == m.name
+	}
+	return false
+}
+
+type namespaceMatcher struct {
+	regexp *regexp.Regexp
+}
+
+func (m namespaceMatcher) Match(value interface{}) bool {
+	switch t := value.(type) {
+	case *v1.Pod:
+		return m.regexp.MatchString(t.Namespace)
+	}
+	return false
+}
+
+// messageMatcher matches log events by their message.
+type messageMatcher struct {
+	regexp *regexp.Regexp
+}
+
+func (m messageMatcher) Match(value interface{}) bool {
+	switch t := value.(type) {
+	case *LogEvent:
+		return m.regexp.MatchString(t.Message)
+	}
+	return false
+}
+
 type labelSelectorMatcher struct {
 	selector labels.Selector
 }
@@ -78,6 +117,20 @@ func (m labelSelectorMatcher) Match(value interface{}) bool {
 	return false
 }
 
+// podMatcher applies a matcher to pods only, which allows negating matchers
+// that only match pods.
+type podMatcher struct {
+	matcher Matcher
+}
+
+func (m podMatcher) Match(value interface{}) bool {
+	switch t := value.(type) {
+	case *v1.Pod:
+		return m.matcher.Match(t)
+	}
+	return false
+}
+
 type trueMatcher struct{}
 
 func (trueMatcher) Match(value interface{}) bool {
@@ -121,3 +174,73 @@ func buildMatcher(
 	}
 	return matcher
 }
+
+func buildNamespaceMatcher(patterns []*regexp.Regexp) Matcher {
+	ors := make(or, len(patterns))
+	for i, r := range patterns {
+		ors[i] = namespaceMatcher{regexp: r}
+	}
+	return ors
+}
+
+func buildContainerNameMatcher(names []string) Matcher {
+	ors := make(or, len(names))
+	for i, name := range names {
+		ors[i] = containerNameMatcher{name: name}
+	}
+	return ors
+}
+
+func buildMessageMatcher(patterns []*regexp.Regexp) Matcher {
+	ors := make(or, len(patterns))
+	for i, r := range patterns {
+		ors[i] = messageMatcher{regexp: r}
+	}
+	return ors
+}
+
+// LabeledMatcher is an inclusion matcher whose label is attached to the
+// events of the pods it selects.
+type LabeledMatcher struct {
+	Matcher Matcher
+	Label   string
+}
+
+// parseLabeledMatcher parses a label selector, optionally followed by a colon
+// and a label, such as "app=checkout:payments".
+func parseLabeledMatcher(expr string) (LabeledMatcher, error) {
+	// Label selectors can't contain colons, so the last one starts the label
+	selectorExpr, label := expr, ""
+	if i := strings.LastIndex(expr, ":"); i >= 0 {
+		selectorExpr, label = expr[:i], expr[i+1:]
+		if label == "" {
+			return LabeledMatcher{}, fmt.Errorf("empty label in %q", expr)
+		}
+	}
+	selector, err := labels.Parse(selectorExpr)
+	if err != nil {
+		return LabeledMatcher{}, err
+	}
+	if selector.Empty() {
+		return LabeledMatcher{}, fmt.Errorf("empty selector in %q", expr)
+	}
+	return LabeledMatcher{Matcher: labelSelectorMatcher{selector}, Label: label}, nil
+}
+
+// matchLabel returns the label of the first matcher that matches the pod.
+func matchLabel(matchers []LabeledMatcher, pod *v1.Pod) string {
+	for _, m := range matchers {
+		if m.Matcher.Match(pod) {
+			return m.Label
+		}
+	}
+	return ""
+}
+
+func buildLabeledMatcher(matchers []LabeledMatcher) Matcher {
+	ors := make(or, len(matchers))
+	for i, m := range matchers {
+		ors[i] = m.Matcher
+	}
+	return ors
+}
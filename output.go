@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// podMetadata selects pod labels and annotations to include in structured
+// output. The key "*" selects everything.
+type podMetadata struct {
+	labels      []string
+	annotations []string
+}
+
+func (m podMetadata) labelsFor(pod *v1.Pod) map[string]string {
+	return selectKeys(pod.Labels, m.labels)
+}
+
+func (m podMetadata) annotationsFor(pod *v1.Pod) map[string]string {
+	return selectKeys(pod.Annotations, m.annotations)
+}
+
+func selectKeys(values map[string]string, keys []string) map[string]string {
+	if len(keys) == 0 || len(values) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for _, key := range keys {
+		if key == "*" {
+			for k, v := range values {
+				result[k] = v
+			}
+			continue
+		}
+		if v, ok := values[key]; ok {
+			result[key] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+type jsonEvent struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Namespace   string            `json:"namespace"`
+	Pod         string            `json:"pod"`
+	Container   string            `json:"container"`
+	Line        int64             `json:"line"`
+	Message     string            `json:"message"`
+	Match       string            `json:"match,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func newJSONPrinter(w io.Writer, metadata podMetadata) func(*LogEvent) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return func(event *LogEvent) error {
+		return encoder.Encode(&jsonEvent{
+			Timestamp:   *event.Timestamp,
+			Namespace:   event.Pod.Namespace,
+			Pod:         event.Pod.Name,
+			Container:   event.Container.Name,
+			Line:        event.LineNumber,
+			Message:     event.Message,
+			Match:       event.MatchLabel,
+			Labels:      metadata.labelsFor(event.Pod),
+			Annotations: metadata.annotationsFor(event.Pod),
+		})
+	}
+}
+
+// newPlainPrinter writes events as uncolored text, with a timestamp and the
+// full name of the container on each line.
+func newPlainPrinter(w io.Writer) func(*LogEvent) error {
+	return func(event *LogEvent) error {
+		_, err := fmt.Fprintf(w, "%s %s/%s:%s %s\n", formatTimestamp(event.Timestamp),
+			event.Pod.Namespace, event.Pod.Name, event.Container.Name, event.Message)
+		return err
+	}
+}
+
+// jsonMarker is written for bookmarks inserted by the user.
+type jsonMarker struct {
+	Timestamp time.Time `json:"timestamp"`
+	Marker    bool      `json:"marker"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// newMarkerPrinter returns a function that writes a bookmark in the given
+// output format, or nil if the format can't represent one.
+func newMarkerPrinter(w io.Writer, format string) func(t time.Time, note string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		return func(t time.Time, note string) error {
+			return encoder.Encode(&jsonMarker{Timestamp: t, Marker: true, Message: note})
+		}
+	case "logfmt":
+		return func(t time.Time, note string) error {
+			var buf bytes.Buffer
+			writeLogfmtPair(&buf, "ts", t.Format(time.RFC3339Nano))
+			writeLogfmtPair(&buf, "marker", "true")
+			if note != "" {
+				writeLogfmtPair(&buf, "msg", note)
+			}
+			buf.WriteByte('\n')
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+	case "text", "plain":
+		return func(t time.Time, note string) error {
+			line := "---- MARK " + formatTimestamp(&t)
+			if note != "" {
+				line += " " + note
+			}
+			line += " ----"
+			if format == "text" {
+				line = colorInfo(line)
+			}
+			_, err := fmt.Fprintln(w, line)
+			return err
+		}
+	}
+	return nil
+}
+
+// logfmtBuiltinKeys are written for every event, and take priority over fields
+// extracted from the message.
+var logfmtBuiltinKeys = []string{"ts", "ns", "pod", "container", "line", "msg", "match"}
+
+func newLogfmtPrinter(w io.Writer, metadata podMetadata) func(*LogEvent) error {
+	return func(event *LogEvent) error {
+		var buf bytes.Buffer
+		writeLogfmtPair(&buf, "ts", event.Timestamp.Format(time.RFC3339Nano))
+		writeLogfmtPair(&buf, "ns", event.Pod.Namespace)
+		writeLogfmtPair(&buf, "pod", event.Pod.Name)
+		writeLogfmtPair(&buf, "container", event.Container.Name)
+		writeLogfmtPair(&buf, "line", strconv.FormatInt(event.LineNumber, 10))
+		writeLogfmtPair(&buf, "msg", event.Message)
+		if event.MatchLabel != "" {
+			writeLogfmtPair(&buf, "match", event.MatchLabel)
+		}
+		writeLogfmtMap(&buf, "label.", metadata.labelsFor(event.Pod))
+		writeLogfmtMap(&buf, "annotation.", metadata.annotationsFor(event.Pod))
+		fields := eventFields(event)
+		for _, k := range logfmtBuiltinKeys {
+			delete(fields, k)
+		}
+		writeLogfmtMap(&buf, "", fields)
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+}
+
+func writeLogfmtMap(buf *bytes.Buffer, prefix string, values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// Keys come from messages, and may be anything
+		if key := logfmtKey(k); key != "" {
+			writeLogfmtPair(buf, prefix+key, values[k])
+		}
+	}
+}
+
+// logfmtKey makes a key valid in logfmt, replacing characters that would end
+// it or need quoting with underscores.
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if isLogfmtSpecial(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// isLogfmtSpecial returns whether a character needs quoting in logfmt.
+func isLogfmtSpecial(r rune) bool {
+	return r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if value == "" || strings.IndexFunc(value, isLogfmtSpecial) >= 0 {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+// eventFields returns the fields of an event's message, using the parser that
+// was configured for its container.
+func eventFields(event *LogEvent) map[string]string {
+	switch event.Parser {
+	case "logfmt":
+		return parseLogfmt(event.Message)
+	case "none":
+		return nil
+	}
+	return extractFields(event.Message)
+}
+
+// extractFields returns the top-level scalar fields of a message that is a
+// JSON object, or nil if the message is not one.
+func extractFields(message string) map[string]string {
+	if len(message) < 2 || message[0] != '{' || message[len(message)-1] != '}' {
+		return nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &object); err != nil {
+		return nil
+	}
+	fields := make(map[string]string, len(object))
+	for k, v := range object {
+		switch t := v.(type) {
+		case string:
+			fields[k] = t
+		case float64:
+			fields[k] = strconv.FormatFloat(t, 'f', -1, 64)
+		case bool:
+			fields[k] = strconv.FormatBool(t)
+		}
+	}
+	return fields
+}
+
+var defaultCSVColumns = []string{"ts", "ns", "pod", "container", "msg"}
+
+// csvColumn returns a function that extracts a column value from an event.
+// Valid columns are ts, ns, pod, container, line, msg, match, label.NAME,
+// annotation.NAME and field.NAME (a field of the message; see eventFields).
+func csvColumn(name string) (func(*LogEvent) string, error) {
+	switch name {
+	case "ts":
+		return func(event *LogEvent) string {
+			return event.Timestamp.Format(time.RFC3339Nano)
+		}, nil
+	case "ns":
+		return func(event *LogEvent) string { return event.Pod.Namespace }, nil
+	case "pod":
+		return func(event *LogEvent) string { return event.Pod.Name }, nil
+	case "container":
+		return func(event *LogEvent) string { return event.Container.Name }, nil
+	case "line":
+		return func(event *LogEvent) string {
+			return strconv.FormatInt(event.LineNumber, 10)
+		}, nil
+	case "msg":
+		return func(event *LogEvent) string { return event.Message }, nil
+	case "match":
+		return func(event *LogEvent) string { return event.MatchLabel }, nil
+	}
+	if key, ok := strings.CutPrefix(name, "label."); ok && key != "" {
+		return func(event *LogEvent) string { return event.Pod.Labels[key] }, nil
+	}
+	if key, ok := strings.CutPrefix(name, "annotation."); ok && key != "" {
+		return func(event *LogEvent) string { return event.Pod.Annotations[key] }, nil
+	}
+	if key, ok := strings.CutPrefix(name, "field."); ok && key != "" {
+		return func(event *LogEvent) string { return eventFields(event)[key] }, nil
+	}
+	return nil, fmt.Errorf("unknown column %q", name)
+}
+
+// newCSVPrinter writes events as CSV records, preceded by a header row if
+// header is set. If separator is a tab, the output is TSV.
+func newCSVPrinter(w io.Writer, columns []string, separator rune, header bool) (func(*LogEvent) error, error) {
+	if len(columns) == 0 {
+		columns = defaultCSVColumns
+	}
+	getters := make([]func(*LogEvent) string, len(columns))
+	for i, name := range columns {
+		getter, err := csvColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		getters[i] = getter
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = separator
+	wroteHeader := !header
+	record := make([]string, len(columns))
+	return func(event *LogEvent) error {
+		if !wroteHeader {
+			if err := writer.Write(columns); err != nil {
+				return err
+			}
+			wroteHeader = true
+		}
+		for i, getter := range getters {
+			record[i] = getter(event)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	}, nil
+}
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case "text", "json", "logfmt", "csv", "tsv", "proto":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
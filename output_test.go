@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWriteLogfmtPairQuoting(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected string
+	}{
+		{"plain", "k=plain"},
+		{"", `k=""`},
+		{"two words", `k="two words"`},
+		{"a=b", `k="a=b"`},
+		{`say "hi"`, `k="say \"hi\""`},
+		{"tab\there", `k="tab\there"`},
+		{"bell\a", `k="bell\a"`},
+		{"grüße", "k=grüße"},
+		{"no\u00a0break", `k="no\u00a0break"`},
+		{`back\slash`, `k=back\slash`},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeLogfmtPair(&buf, "k", test.value)
+			if buf.String() != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteLogfmtMapKeys(t *testing.T) {
+	var buf bytes.Buffer
+	writeLogfmtMap(&buf, "", map[string]string{
+		"":          "empty",
+		"two words": "1",
+		"a=b":       "2",
+		`say "hi"`:  "3",
+		"tab\there": "4",
+		"grüße":     "5",
+		"user.id":   "6",
+	})
+	expected := `a_b=2 grüße=5 say__hi_=3 tab_here=4 two_words=1 user.id=6`
+	if buf.String() != expected {
+		t.Errorf("expected %s, got %s", expected, buf.String())
+	}
+
+	fields := parseLogfmt(buf.String())
+	if len(fields) != 6 {
+		t.Errorf("expected 6 fields to parse back, got %v", fields)
+	}
+}
+
+func TestLogfmtPrinterRoundTrip(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 10, 20, 30, 123456789, time.UTC)
+	event := &LogEvent{
+		Pod: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "shop",
+				Name:        "checkout-1",
+				Labels:      map[string]string{"app": "checkout"},
+				Annotations: map[string]string{"owner": "payments team"},
+			},
+		},
+		Container:  &v1.Container{Name: "app"},
+		Timestamp:  &timestamp,
+		Message:    `{"msg": "ignored", "status": 503, "path": "/pay now", "ok": false}`,
+		LineNumber: 42,
+		MatchLabel: "frontend",
+	}
+
+	var buf bytes.Buffer
+	printEvent := newLogfmtPrinter(&buf, podMetadata{labels: []string{"*"}, annotations: []string{"owner"}})
+	if err := printEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	if !strings.HasSuffix(output, "\n") || strings.Count(output, "\n") != 1 {
+		t.Fatalf("expected a single line, got %q", output)
+	}
+	if !strings.HasPrefix(output, "ts=2024-05-01T10:20:30.123456789Z ns=shop pod=checkout-1 container=app line=42 ") {
+		t.Errorf("built-in keys are not first, in order: %q", output)
+	}
+
+	fields := parseLogfmt(strings.TrimSuffix(output, "\n"))
+	expected := map[string]string{
+		"ts":               "2024-05-01T10:20:30.123456789Z",
+		"ns":               "shop",
+		"pod":              "checkout-1",
+		"container":        "app",
+		"line":             "42",
+		"msg":              event.Message, // Not the message's own msg field
+		"match":            "frontend",
+		"label.app":        "checkout",
+		"annotation.owner": "payments team",
+		"status":           "503",
+		"path":             "/pay now",
+		"ok":               "false",
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("expected %s = %q, got %q", k, v, fields[k])
+		}
+	}
+}
+
+func TestLogfmtPrinterUsesContainerParser(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC)
+	for _, test := range []struct {
+		parser   string
+		message  string
+		expected string
+	}{
+		{"", `{"level": "info"}`, ` level=info`},
+		{"logfmt", `level=warn user="a b"`, ` level=warn user="a b"`},
+		{"none", `level=warn`, ""},
+	} {
+		t.Run(test.parser, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := newLogfmtPrinter(&buf, podMetadata{})(&LogEvent{
+				Pod:       &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "p"}},
+				Container: &v1.Container{Name: "c"},
+				Timestamp: &timestamp,
+				Message:   test.message,
+				Parser:    test.parser,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg bytes.Buffer
+			writeLogfmtPair(&msg, "msg", test.message)
+			expected := "ts=2024-05-01T10:20:30Z ns=shop pod=p container=c line=0 " + msg.String() +
+				test.expected + "\n"
+			if buf.String() != expected {
+				t.Errorf("expected %q, got %q", expected, buf.String())
+			}
+		})
+	}
+}
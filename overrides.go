@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// tailerOverrides adjust the tailer options of the containers that match
+// overrides in the config.
+type tailerOverrides []tailerOverride
+
+type tailerOverride struct {
+	selector containerSelector
+	config   Override
+}
+
+func newTailerOverrides(configs []Override) (tailerOverrides, error) {
+	overrides := make(tailerOverrides, len(configs))
+	for i, config := range configs {
+		selector, err := newContainerSelector(config.Namespace, config.Pod, config.Container)
+		if err == nil {
+			err = validateOverride(config)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("override #%d: %w", i+1, err)
+		}
+		overrides[i] = tailerOverride{selector: selector, config: config}
+	}
+	return overrides, nil
+}
+
+func validateOverride(config Override) error {
+	if config.TailLines != nil && *config.TailLines < 0 {
+		return fmt.Errorf("tailLines must not be negative")
+	}
+	if config.RateLimit != nil && *config.RateLimit <= 0 {
+		return fmt.Errorf("rateLimit must be positive")
+	}
+	if retry := config.Retry; retry != nil {
+		if retry.Min.Duration < 0 || retry.Max.Duration < 0 {
+			return fmt.Errorf("retry intervals must not be negative")
+		}
+		if retry.Max.Duration > 0 && retry.Min.Duration > retry.Max.Duration {
+			return fmt.Errorf("retry min must not be greater than max")
+		}
+	}
+	switch config.Parser {
+	case "", "json", "logfmt", "none":
+	default:
+		return fmt.Errorf("unknown parser %q", config.Parser)
+	}
+	return nil
+}
+
+// apply returns the options for tailing a container.
+func (o tailerOverrides) apply(pod *v1.Pod, container *v1.Container, options TailerOptions) TailerOptions {
+	for _, override := range o {
+		if !override.selector.matches(pod, container) {
+			continue
+		}
+		config := override.config
+		if config.TailLines != nil {
+			options.TailLines = config.TailLines
+		}
+		if config.RateLimit != nil {
+			options.RateLimit = *config.RateLimit
+		}
+		if config.Retry != nil {
+			options.RetryMin = config.Retry.Min.Duration
+			options.RetryMax = config.Retry.Max.Duration
+		}
+		if config.Parser != "" {
+			options.Parser = config.Parser
+		}
+	}
+	return options
+}
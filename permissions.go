@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permission is an action on a resource that is needed for tailing.
+type permission struct {
+	verb        string
+	resource    string
+	subresource string
+}
+
+func (p permission) String() string {
+	if p.subresource != "" {
+		return fmt.Sprintf("%s %s/%s", p.verb, p.resource, p.subresource)
+	}
+	return fmt.Sprintf("%s %s", p.verb, p.resource)
+}
+
+// maxConcurrentAccessReviews limits how many namespaces are probed at once.
+const maxConcurrentAccessReviews = 10
+
+// tailPermissions are the permissions needed to tail pods in a namespace.
+var tailPermissions = []permission{
+	{verb: "list", resource: "pods"},
+	{verb: "watch", resource: "pods"},
+	{verb: "get", resource: "pods", subresource: "log"},
+}
+
+// missingPermissions asks the API server which of the given permissions the
+// current user lacks in a namespace. An empty namespace means all namespaces.
+func missingPermissions(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	permissions []permission) ([]permission, error) {
+	var missing []permission
+	for _, p := range permissions {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx,
+			&authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        p.verb,
+						Resource:    p.resource,
+						Subresource: p.subresource,
+					},
+				},
+			}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("checking permission to %s: %w", p, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// checkTailPermissions writes a report of the permissions needed for tailing
+// that the current user has in each namespace. It returns false if any are
+// missing.
+func checkTailPermissions(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespaces []string,
+	w io.Writer) bool {
+	missing, err := probeNamespaces(ctx, client, namespaces)
+	if err != nil {
+		// Access reviews may be unavailable; the check is best effort
+		printError("Could not check permissions: %s", err)
+		return true
+	}
+
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprint(tw, "NAMESPACE")
+	for _, p := range tailPermissions {
+		_, _ = fmt.Fprintf(tw, "\t%s", strings.ToUpper(p.String()))
+	}
+	_, _ = fmt.Fprintln(tw)
+	for _, ns := range namespaces {
+		name := ns
+		if ns == "" {
+			name = "(all)"
+		}
+		_, _ = fmt.Fprint(tw, name)
+		for _, p := range tailPermissions {
+			allowed := "yes"
+			if slices.Contains(missing[ns], p) {
+				allowed, ok = "no", false
+			}
+			_, _ = fmt.Fprintf(tw, "\t%s", allowed)
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+	_ = tw.Flush()
+	return ok
+}
+
+// probeNamespaces asks which permissions needed for tailing the current user
+// lacks in each namespace, probing several namespaces at once.
+func probeNamespaces(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespaces []string) (map[string][]permission, error) {
+	var (
+		result   = make(map[string][]permission, len(namespaces))
+		firstErr error
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, maxConcurrentAccessReviews)
+	for _, namespace := range namespaces {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ns string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			missing, err := missingPermissions(ctx, client, ns, tailPermissions)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			result[ns] = missing
+		}(namespace)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// accessibleNamespaces returns the namespaces in which the current user has
+// all the permissions needed for tailing.
+func accessibleNamespaces(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	namespaceList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+	names := make([]string, len(namespaceList.Items))
+	for i, namespace := range namespaceList.Items {
+		names[i] = namespace.Name
+	}
+
+	missing, err := probeNamespaces(ctx, client, names)
+	if err != nil {
+		return nil, err
+	}
+	var accessible []string
+	for _, ns := range names {
+		if len(missing[ns]) == 0 {
+			accessible = append(accessible, ns)
+		}
+	}
+	sort.Strings(accessible)
+	return accessible, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// legendInterval is the minimum time between full legends of prefix aliases.
+// In between, only the aliases of new streams are listed.
+const legendInterval = time.Minute
+
+// prefixAliases replaces the prefixes of streams with short aliases, like
+// "p1", to leave more of narrow terminals for messages. Aliases are never
+// reused, so they mean the same thing for the whole session, but the legend
+// only lists those of live streams.
+type prefixAliases struct {
+	aliases    map[string]string
+	prefixes   []string
+	live       map[string]prefixAlias
+	lastLegend time.Time
+	sync.Mutex
+}
+
+// prefixAlias is an entry of the legend.
+type prefixAlias struct {
+	alias    string
+	prefix   string
+	colorKey []string
+}
+
+func newPrefixAliases() *prefixAliases {
+	return &prefixAliases{
+		aliases: map[string]string{},
+		live:    map[string]prefixAlias{},
+	}
+}
+
+// get returns the alias of a prefix. If the prefix is new, or its stream had
+// ended, it also returns the legend to print before using the alias: the full
+// legend if it hasn't been printed recently, and otherwise just this alias.
+func (a *prefixAliases) get(prefix string, colorKey []string) (string, []prefixAlias) {
+	a.Lock()
+	defer a.Unlock()
+
+	if entry, ok := a.live[prefix]; ok {
+		return entry.alias, nil
+	}
+	alias, ok := a.aliases[prefix]
+	if !ok {
+		alias = "p" + strconv.Itoa(len(a.prefixes)+1)
+		a.aliases[prefix] = alias
+		a.prefixes = append(a.prefixes, prefix)
+	}
+	entry := prefixAlias{alias: alias, prefix: prefix, colorKey: colorKey}
+	a.live[prefix] = entry
+
+	if now := time.Now(); now.Sub(a.lastLegend) >= legendInterval {
+		a.lastLegend = now
+		legend := make([]prefixAlias, 0, len(a.live))
+		for _, prefix := range a.prefixes {
+			if entry, ok := a.live[prefix]; ok {
+				legend = append(legend, entry)
+			}
+		}
+		return alias, legend
+	}
+	return alias, []prefixAlias{entry}
+}
+
+// remove leaves a prefix out of the legend once its stream has ended. It
+// keeps its alias if the stream comes back.
+func (a *prefixAliases) remove(prefix string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.live, prefix)
+}
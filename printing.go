@@ -2,21 +2,72 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
 )
 
+// stderr is where messages are printed. It's replaced with a console writer
+// when color is enabled.
+var stderr io.Writer = os.Stderr
+
 func printInfo(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
-	_, _ = fmt.Fprint(os.Stderr, colorInfo("==> "+message+"\n"))
+	_, _ = fmt.Fprint(stderr, colorInfo("==> "+message+"\n"))
 }
 
 func printError(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
-	_, _ = fmt.Fprint(os.Stderr, colorError("==> "+message+"\n"))
+	_, _ = fmt.Fprint(stderr, colorError("==> "+message+"\n"))
+}
+
+// describeContainerState summarizes a container's state from the pod status,
+// including why it last exited and how often it has restarted, such as
+// "waiting: CrashLoopBackOff, restarted 5 times, last exit: OOMKilled (code 137)".
+func describeContainerState(pod *v1.Pod, container *v1.Container) string {
+	for _, status := range allContainerStatusesForPod(pod) {
+		if status.Name != container.Name {
+			continue
+		}
+
+		var parts []string
+		switch state := status.State; {
+		case state.Running != nil:
+			parts = append(parts, "running")
+		case state.Waiting != nil:
+			if state.Waiting.Reason != "" {
+				parts = append(parts, "waiting: "+state.Waiting.Reason)
+			} else {
+				parts = append(parts, "waiting")
+			}
+		case state.Terminated != nil:
+			parts = append(parts, "terminated: "+describeTermination(state.Terminated))
+		}
+		if status.RestartCount == 1 {
+			parts = append(parts, "restarted once")
+		} else if status.RestartCount > 1 {
+			parts = append(parts, fmt.Sprintf("restarted %d times", status.RestartCount))
+		}
+		if last := status.LastTerminationState.Terminated; last != nil && status.State.Terminated == nil {
+			parts = append(parts, "last exit: "+describeTermination(last))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+func describeTermination(state *v1.ContainerStateTerminated) string {
+	if state.Signal != 0 {
+		return fmt.Sprintf("%s (code %d, signal %d)", state.Reason, state.ExitCode, state.Signal)
+	}
+	if state.Reason == "" {
+		return fmt.Sprintf("code %d", state.ExitCode)
+	}
+	return fmt.Sprintf("%s (code %d)", state.Reason, state.ExitCode)
 }
 
 func formatTimestamp(t *time.Time) string {
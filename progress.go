@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// progressStatus is written periodically with --progress json.
+type progressStatus struct {
+	Time             time.Time        `json:"time"`
+	Namespaces       int              `json:"namespaces"`
+	NamespacesSynced int              `json:"namespacesSynced"`
+	Pods             int              `json:"pods"`
+	Streams          int              `json:"streams"`
+	Lines            int64            `json:"lines"`
+	Bytes            int64            `json:"bytes"`
+	MaxLagMillis     int64            `json:"maxLagMs"`
+	StreamStatus     []progressStream `json:"streamStatus,omitempty"`
+}
+
+type progressStream struct {
+	Namespace     string     `json:"namespace"`
+	Pod           string     `json:"pod"`
+	Container     string     `json:"container"`
+	Bytes         int64      `json:"bytes"`
+	LagMillis     int64      `json:"lagMs"`
+	LastTimestamp *time.Time `json:"lastTimestamp,omitempty"`
+}
+
+// reportProgress writes a status line at every interval until the context is
+// cancelled.
+func reportProgress(
+	ctx context.Context,
+	w io.Writer,
+	interval time.Duration,
+	controller *Controller,
+	lines func() int64) error {
+	encoder := json.NewEncoder(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if err := encoder.Encode(buildProgressStatus(now, controller.Stats(), lines())); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func buildProgressStatus(now time.Time, stats ControllerStats, lines int64) *progressStatus {
+	status := &progressStatus{
+		Time:             now.UTC(),
+		Namespaces:       stats.Namespaces,
+		NamespacesSynced: stats.NamespacesSynced,
+		Pods:             stats.Pods,
+		Streams:          stats.Streams,
+		Lines:            lines,
+	}
+	for _, s := range stats.StreamStats {
+		stream := progressStream{
+			Namespace: s.Pod.Namespace,
+			Pod:       s.Pod.Name,
+			Container: s.Container.Name,
+			Bytes:     s.BytesRead,
+		}
+		if !s.LastTimestamp.IsZero() {
+			t := s.LastTimestamp.UTC()
+			stream.LastTimestamp = &t
+			stream.LagMillis = now.Sub(t).Milliseconds()
+		}
+		status.Bytes += stream.Bytes
+		status.MaxLagMillis = max(status.MaxLagMillis, stream.LagMillis)
+		status.StreamStatus = append(status.StreamStatus, stream)
+	}
+	sort.Slice(status.StreamStatus, func(i, j int) bool {
+		a, b := status.StreamStatus[i], status.StreamStatus[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		return a.Container < b.Container
+	})
+	return status
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the Event message in docs/event.proto.
+const (
+	protoFieldTimestamp   protowire.Number = 1
+	protoFieldNamespace   protowire.Number = 2
+	protoFieldPod         protowire.Number = 3
+	protoFieldContainer   protowire.Number = 4
+	protoFieldLine        protowire.Number = 5
+	protoFieldMessage     protowire.Number = 6
+	protoFieldLabels      protowire.Number = 7
+	protoFieldAnnotations protowire.Number = 8
+	protoFieldMatch       protowire.Number = 9
+)
+
+// newProtoPrinter writes events as varint length-prefixed protobuf messages.
+// The schema is published in docs/event.proto.
+func newProtoPrinter(w io.Writer, metadata podMetadata) func(*LogEvent) error {
+	var msg, frame []byte
+	return func(event *LogEvent) error {
+		msg = msg[:0]
+		msg = appendProtoVarint(msg, protoFieldTimestamp, uint64(event.Timestamp.UnixNano()))
+		msg = appendProtoString(msg, protoFieldNamespace, event.Pod.Namespace)
+		msg = appendProtoString(msg, protoFieldPod, event.Pod.Name)
+		msg = appendProtoString(msg, protoFieldContainer, event.Container.Name)
+		msg = appendProtoVarint(msg, protoFieldLine, uint64(event.LineNumber))
+		msg = appendProtoString(msg, protoFieldMessage, event.Message)
+		msg = appendProtoMap(msg, protoFieldLabels, metadata.labelsFor(event.Pod))
+		msg = appendProtoMap(msg, protoFieldAnnotations, metadata.annotationsFor(event.Pod))
+		msg = appendProtoString(msg, protoFieldMatch, event.MatchLabel)
+
+		frame = protowire.AppendVarint(frame[:0], uint64(len(msg)))
+		frame = append(frame, msg...)
+		_, err := w.Write(frame)
+		return err
+	}
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendProtoString encodes a string field. Decoders reject strings that
+// aren't valid UTF-8, so invalid sequences are replaced.
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, strings.ToValidUTF8(s, "\uFFFD"))
+}
+
+// appendProtoMap encodes a map<string, string> field as a sequence of entry
+// messages, in key order so the output is deterministic.
+func appendProtoMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var entry []byte
+	for _, k := range keys {
+		entry = entry[:0]
+		entry = appendProtoString(entry, 1, k)
+		entry = appendProtoString(entry, 2, m[k])
+
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// protoSchemaFields reads the field numbers of the Event message from
+// docs/event.proto.
+func protoSchemaFields(t *testing.T) map[string]protowire.Number {
+	t.Helper()
+	data, err := os.ReadFile("docs/event.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]protowire.Number{}
+	field := regexp.MustCompile(`(?m)^\s+[\w<>, ]+\s(\w+) = (\d+);`)
+	for _, match := range field.FindAllStringSubmatch(string(data), -1) {
+		n, _ := strconv.Atoi(match[2])
+		fields[match[1]] = protowire.Number(n)
+	}
+	return fields
+}
+
+func TestProtoFieldsMatchSchema(t *testing.T) {
+	fields := protoSchemaFields(t)
+	for name, number := range map[string]protowire.Number{
+		"timestamp_unix_nano": protoFieldTimestamp,
+		"namespace":           protoFieldNamespace,
+		"pod":                 protoFieldPod,
+		"container":           protoFieldContainer,
+		"line":                protoFieldLine,
+		"message":             protoFieldMessage,
+		"labels":              protoFieldLabels,
+		"annotations":         protoFieldAnnotations,
+		"match":               protoFieldMatch,
+	} {
+		if fields[name] != number {
+			t.Errorf("field %s: schema has %d, printer uses %d", name, fields[name], number)
+		}
+		delete(fields, name)
+	}
+	for name := range fields {
+		t.Errorf("field %s of the schema is not written", name)
+	}
+}
+
+func TestProtoPrinterRoundTrip(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 10, 20, 30, 123456789, time.UTC)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "shop",
+			Name:        "checkout-1",
+			Labels:      map[string]string{"app": "checkout", "tier": "web"},
+			Annotations: map[string]string{"owner": "payments"},
+		},
+	}
+	epoch := time.Unix(0, 0)
+	events := []LogEvent{
+		{
+			Pod:        pod,
+			Container:  &v1.Container{Name: "app"},
+			Timestamp:  &timestamp,
+			Message:    "grüße, \"world\"",
+			LineNumber: 300,
+			MatchLabel: "frontend",
+		},
+		{
+			// Zero values are left out, like proto3 does
+			Pod:       &v1.Pod{},
+			Container: &v1.Container{},
+			Timestamp: &epoch,
+		},
+	}
+
+	var buf bytes.Buffer
+	printEvent := newProtoPrinter(&buf, podMetadata{labels: []string{"*"}, annotations: []string{"owner"}})
+	for i := range events {
+		if err := printEvent(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields := protoSchemaFields(t)
+	data := buf.Bytes()
+	for i, event := range events {
+		size, n := protowire.ConsumeVarint(data)
+		if n < 0 || uint64(len(data)-n) < size {
+			t.Fatalf("event %d: invalid length prefix", i)
+		}
+		msg := data[n : n+int(size)]
+		data = data[n+int(size):]
+
+		decoded := decodeProtoEvent(t, msg, fields)
+		expected := map[string]string{}
+		if ns := event.Timestamp.UnixNano(); ns != 0 {
+			expected["timestamp_unix_nano"] = strconv.FormatInt(ns, 10)
+		}
+		for name, value := range map[string]string{
+			"namespace": event.Pod.Namespace,
+			"pod":       event.Pod.Name,
+			"container": event.Container.Name,
+			"message":   event.Message,
+			"match":     event.MatchLabel,
+		} {
+			if value != "" {
+				expected[name] = value
+			}
+		}
+		if event.LineNumber != 0 {
+			expected["line"] = strconv.FormatInt(event.LineNumber, 10)
+		}
+		if event.Pod == pod {
+			expected["labels.app"] = "checkout"
+			expected["labels.tier"] = "web"
+			expected["annotations.owner"] = "payments"
+		}
+
+		if len(decoded) != len(expected) {
+			t.Errorf("event %d: expected %v, got %v", i, expected, decoded)
+		}
+		for k, v := range expected {
+			if decoded[k] != v {
+				t.Errorf("event %d: expected %s = %q, got %q", i, k, v, decoded[k])
+			}
+		}
+	}
+	if len(data) > 0 {
+		t.Errorf("%d bytes left over", len(data))
+	}
+}
+
+// decodeProtoEvent decodes an Event message into its fields by name, with map
+// entries as "field.key".
+func decodeProtoEvent(t *testing.T, msg []byte, fields map[string]protowire.Number) map[string]string {
+	t.Helper()
+	names := map[protowire.Number]string{}
+	for name, number := range fields {
+		names[number] = name
+	}
+
+	decoded := map[string]string{}
+	for len(msg) > 0 {
+		number, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			t.Fatalf("invalid tag: %s", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		name, ok := names[number]
+		if !ok {
+			t.Fatalf("unknown field %d", number)
+		}
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				t.Fatalf("field %s: %s", name, protowire.ParseError(n))
+			}
+			msg = msg[n:]
+			decoded[name] = strconv.FormatInt(int64(v), 10)
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				t.Fatalf("field %s: %s", name, protowire.ParseError(n))
+			}
+			msg = msg[n:]
+			if name != "labels" && name != "annotations" {
+				decoded[name] = string(v)
+				continue
+			}
+			var key, value string
+			for len(v) > 0 {
+				entryNumber, _, n := protowire.ConsumeTag(v)
+				if n < 0 {
+					t.Fatalf("field %s: %s", name, protowire.ParseError(n))
+				}
+				v = v[n:]
+				s, n := protowire.ConsumeString(v)
+				if n < 0 {
+					t.Fatalf("field %s: %s", name, protowire.ParseError(n))
+				}
+				v = v[n:]
+				if entryNumber == 1 {
+					key = s
+				} else {
+					value = s
+				}
+			}
+			decoded[name+"."+key] = value
+		default:
+			t.Fatalf("field %s: unexpected wire type %d", name, typ)
+		}
+	}
+	return decoded
+}
+
+func TestProtoPrinterReplacesInvalidUTF8(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC)
+	var buf bytes.Buffer
+	err := newProtoPrinter(&buf, podMetadata{})(&LogEvent{
+		Pod:       &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "p"}},
+		Container: &v1.Container{Name: "c"},
+		Timestamp: &timestamp,
+		Message:   "caf\xe9 \xff\xfe ok",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	size, n := protowire.ConsumeVarint(data)
+	if n < 0 || uint64(len(data)-n) != size {
+		t.Fatal("invalid length prefix")
+	}
+	decoded := decodeProtoEvent(t, data[n:], protoSchemaFields(t))
+	if expected := "caf\uFFFD \uFFFD ok"; decoded["message"] != expected {
+		t.Errorf("expected %q, got %q", expected, decoded["message"])
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Query is a compiled LogQL-style query, such as:
+//
+//	{app="checkout", container!="istio-proxy"} |= "timeout" | json | status >= 500
+//
+// The stream selector decides which containers are tailed, and the pipeline
+// decides which of their lines are shown.
+type Query struct {
+	// Exclusion matches the pods and containers that the stream selector
+	// doesn't select.
+	Exclusion Matcher
+
+	// Lines matches the log events that pass the pipeline, or is nil if the
+	// query has no pipeline.
+	Lines Matcher
+}
+
+// ParseQuery parses and compiles a LogQL-style query.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+
+	query := &Query{Exclusion: falseMatcher{}}
+	if p.peek().value == "{" {
+		exclusion, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		query.Exclusion = exclusion
+	}
+
+	var pipeline queryPipeline
+	for !p.done() {
+		stage, err := p.parseStage()
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, stage)
+	}
+	if len(pipeline) > 0 {
+		query.Lines = pipeline
+	}
+	return query, nil
+}
+
+type queryTokenKind int
+
+const (
+	queryOperator queryTokenKind = iota
+	queryIdentifier
+	queryString
+	queryNumber
+	queryEnd
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	value string
+}
+
+// queryOperators are ordered so that longer operators are matched first.
+var queryOperators = []string{
+	"|=", "|~", "!=", "!~", "=~", "==", ">=", "<=", "|", "=", ">", "<", "{", "}", ",",
+}
+
+func tokenizeQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(s); {
+		c, _ := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '`':
+			end := i + 1
+			for end < len(s) && rune(s[end]) != c {
+				if c == '"' && s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i, err)
+			}
+			tokens = append(tokens, queryToken{kind: queryString, value: value})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(c):
+			end := i
+			for end < len(s) {
+				r, size := utf8.DecodeRuneInString(s[end:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, queryToken{kind: queryIdentifier, value: s[i:end]})
+			i = end
+		case c == '-' || isASCIIDigit(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '.' || isASCIIDigit(rune(s[end]))) {
+				end++
+			}
+			tokens = append(tokens, queryToken{kind: queryNumber, value: s[i:end]})
+			i = end
+		default:
+			matched := false
+			for _, op := range queryOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, queryToken{kind: queryOperator, value: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *queryParser) peek() queryToken {
+	if p.done() {
+		return queryToken{kind: queryEnd}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.peek()
+	if !p.done() {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) expect(kind queryTokenKind, what string) (string, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == queryEnd {
+			return "", fmt.Errorf("expected %s, got end of query", what)
+		}
+		return "", fmt.Errorf("expected %s, got %q", what, t.value)
+	}
+	return t.value, nil
+}
+
+// parseSelector parses a stream selector into a matcher of the pods and
+// containers it doesn't select.
+func (p *queryParser) parseSelector() (Matcher, error) {
+	p.next()
+	var excluded or
+	for p.peek().value != "}" {
+		name, err := p.expect(queryIdentifier, "label name")
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+		if op.kind != queryOperator || !(op.value == "=" || op.value == "!=" ||
+			op.value == "=~" || op.value == "!~") {
+			return nil, fmt.Errorf("expected label matcher operator after %q", name)
+		}
+		value, err := p.expect(queryString, "quoted label value")
+		if err != nil {
+			return nil, err
+		}
+		cond, err := newQueryCondition(op.value, value)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, selectorMatcher{name: name, cond: cond})
+
+		if p.peek().value == "," {
+			p.next()
+		} else if p.peek().value != "}" {
+			return nil, fmt.Errorf("expected ',' or '}' in stream selector")
+		}
+	}
+	p.next()
+	if len(excluded) == 0 {
+		return falseMatcher{}, nil
+	}
+	return excluded, nil
+}
+
+func (p *queryParser) parseStage() (queryStage, error) {
+	op := p.next()
+	switch op.value {
+	case "|=", "!=", "|~", "!~":
+		value, err := p.expect(queryString, "quoted string after "+op.value)
+		if err != nil {
+			return nil, err
+		}
+		return newLineFilter(op.value, value)
+	case "|":
+		name, err := p.expect(queryIdentifier, "parser or label filter after '|'")
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "json":
+			return parserStage(extractFields), nil
+		case "logfmt":
+			return parserStage(parseLogfmt), nil
+		}
+		cmp := p.next()
+		if cmp.kind != queryOperator {
+			return nil, fmt.Errorf("expected comparison operator after %q", name)
+		}
+		value := p.next()
+		if value.kind != queryString && value.kind != queryNumber {
+			return nil, fmt.Errorf("expected value after %q %s", name, cmp.value)
+		}
+		cond, err := newQueryCondition(cmp.value, value.value)
+		if err != nil {
+			return nil, err
+		}
+		return fieldFilter{name: name, cond: cond}, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	return nil, fmt.Errorf("expected line filter or '|', got %q", op.value)
+}
+
+// queryCondition tests a label or field value.
+type queryCondition func(value string) bool
+
+func newQueryCondition(op, operand string) (queryCondition, error) {
+	switch op {
+	case "=", "==":
+		return func(v string) bool { return v == operand }, nil
+	case "!=":
+		return func(v string) bool { return v != operand }, nil
+	case "=~", "!~":
+		// Like Loki, regular expressions must match the entire value
+		r, err := regexp.Compile("^(?:" + operand + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", operand, err)
+		}
+		if op == "!~" {
+			return func(v string) bool { return !r.MatchString(v) }, nil
+		}
+		return r.MatchString, nil
+	case ">", ">=", "<", "<=":
+		n, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s requires a number, got %q", op, operand)
+		}
+		return func(v string) bool {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return false
+			}
+			switch op {
+			case ">":
+				return f > n
+			case ">=":
+				return f >= n
+			case "<":
+				return f < n
+			default:
+				return f <= n
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// selectorMatcher matches the pods or containers whose label doesn't satisfy
+// a condition. The labels "namespace", "pod" and "container" refer to names.
+type selectorMatcher struct {
+	name string
+	cond queryCondition
+}
+
+func (m selectorMatcher) Match(value interface{}) bool {
+	switch t := value.(type) {
+	case *v1.Pod:
+		switch m.name {
+		case "container":
+			return false
+		case "namespace":
+			return !m.cond(t.Namespace)
+		case "pod":
+			return !m.cond(t.Name)
+		}
+		return !m.cond(t.Labels[m.name])
+	case *v1.Container:
+		if m.name == "container" {
+			return !m.cond(t.Name)
+		}
+	}
+	return false
+}
+
+// queryStage is a step of a query pipeline. It may add to the fields of the
+// event, and returns false if the event should be dropped.
+type queryStage interface {
+	apply(event *LogEvent, fields map[string]string) bool
+}
+
+type queryPipeline []queryStage
+
+func (q queryPipeline) Match(value interface{}) bool {
+	event, ok := value.(*LogEvent)
+	if !ok {
+		return false
+	}
+	fields := map[string]string{}
+	for _, stage := range q {
+		if !stage.apply(event, fields) {
+			return false
+		}
+	}
+	return true
+}
+
+type lineFilter struct {
+	contains string
+	regexp   *regexp.Regexp
+	negate   bool
+}
+
+func newLineFilter(op, value string) (queryStage, error) {
+	f := lineFilter{negate: op[0] == '!'}
+	if op[1] == '~' {
+		r, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", value, err)
+		}
+		f.regexp = r
+	} else {
+		f.contains = value
+	}
+	return f, nil
+}
+
+func (f lineFilter) apply(event *LogEvent, _ map[string]string) bool {
+	var matched bool
+	if f.regexp != nil {
+		matched = f.regexp.MatchString(event.Message)
+	} else {
+		matched = strings.Contains(event.Message, f.contains)
+	}
+	return matched != f.negate
+}
+
+// parserStage extracts fields from the message.
+type parserStage func(message string) map[string]string
+
+func (p parserStage) apply(event *LogEvent, fields map[string]string) bool {
+	for k, v := range p(event.Message) {
+		fields[k] = v
+	}
+	return true
+}
+
+// fieldFilter tests a field extracted by a parser. Fields that weren't
+// extracted fall back to the stream's labels, like in a stream selector.
+type fieldFilter struct {
+	name string
+	cond queryCondition
+}
+
+func (f fieldFilter) apply(event *LogEvent, fields map[string]string) bool {
+	value, ok := fields[f.name]
+	if !ok {
+		switch f.name {
+		case "namespace":
+			value = event.Pod.Namespace
+		case "pod":
+			value = event.Pod.Name
+		case "container":
+			value = event.Container.Name
+		default:
+			value = event.Pod.Labels[f.name]
+		}
+	}
+	return f.cond(value)
+}
+
+// parseLogfmt returns the key/value pairs of a logfmt message.
+func parseLogfmt(message string) map[string]string {
+	fields := map[string]string{}
+	s := message
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return fields
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			return fields
+		}
+		key := s[:end]
+		s = s[end:]
+		if s[0] != '=' {
+			continue
+		}
+		s = s[1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return fields
+			}
+			unquoted, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				unquoted = s[1:i]
+			}
+			value, s = unquoted, s[i+1:]
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		if key != "" {
+			fields[key] = value
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newQueryEvent(message string) *LogEvent {
+	timestamp := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return &LogEvent{
+		Pod: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "shop",
+				Name:      "checkout-1",
+				Labels:    map[string]string{"app": "checkout", "tier": "web"},
+			},
+		},
+		Container: &v1.Container{Name: "app"},
+		Timestamp: &timestamp,
+		Message:   message,
+	}
+}
+
+func TestQuerySelector(t *testing.T) {
+	event := newQueryEvent("")
+	sidecar := &v1.Container{Name: "istio-proxy"}
+
+	for _, test := range []struct {
+		query            string
+		excludesPod      bool
+		excludesApp      bool
+		excludesSidecar  bool
+		expectedPipeline bool
+	}{
+		{query: `{app="checkout"}`},
+		{query: `{app="cart"}`, excludesPod: true},
+		{query: `{app!="checkout"}`, excludesPod: true},
+		{query: `{app=~"check.*"}`},
+		{query: `{app=~"check"}`, excludesPod: true},
+		{query: `{app!~"cart|orders"}`},
+		{query: `{namespace="shop", pod="checkout-1"}`},
+		{query: `{namespace="other"}`, excludesPod: true},
+		{query: `{container!="istio-proxy"}`, excludesSidecar: true},
+		{query: `{container="app"}`, excludesSidecar: true},
+		{query: `{}`},
+		{query: `{app="checkout"} |= "timeout"`, expectedPipeline: true},
+		{query: `|= "timeout"`, expectedPipeline: true},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if excluded := query.Exclusion.Match(event.Pod); excluded != test.excludesPod {
+				t.Errorf("expected pod excluded = %t, got %t", test.excludesPod, excluded)
+			}
+			if excluded := query.Exclusion.Match(event.Container); excluded != test.excludesApp {
+				t.Errorf("expected app container excluded = %t, got %t", test.excludesApp, excluded)
+			}
+			if excluded := query.Exclusion.Match(sidecar); excluded != test.excludesSidecar {
+				t.Errorf("expected sidecar excluded = %t, got %t", test.excludesSidecar, excluded)
+			}
+			if (query.Lines != nil) != test.expectedPipeline {
+				t.Errorf("expected pipeline = %t, got %v", test.expectedPipeline, query.Lines)
+			}
+		})
+	}
+}
+
+func TestQueryPipeline(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		message  string
+		expected bool
+	}{
+		{`|= "timeout"`, "request timeout", true},
+		{`|= "timeout"`, "request ok", false},
+		{`!= "timeout"`, "request ok", true},
+		{`|~ "time(out)?"`, "request time", true},
+		{`!~ "^GET"`, "GET /", false},
+		{"|= `a\\b`", `a\b`, true},
+		{`|= "a\"b"`, `a"b`, true},
+		{`|= "error" != "retry"`, "error, will retry", false},
+		{`| json | status >= 500`, `{"status": 503}`, true},
+		{`| json | status >= 500`, `{"status": 200}`, false},
+		{`| json | status < 300`, `{"status": "ok"}`, false},
+		{`| json | status < -1.5`, `{"status": -2}`, true},
+		{`| json | level="error"`, `{"level": "error"}`, true},
+		{`| json | level=~"warn|error"`, `{"level": "warn"}`, true},
+		{`| json | level!="error"`, `not json`, true},
+		{`| logfmt | user.id="42"`, `msg="hello" user.id=42`, true},
+		{`| logfmt | user_id == "42"`, `user_id=43`, false},
+		{`| app="checkout"`, "anything", true},
+		{`| pod="checkout-1" | container="app"`, "anything", true},
+		{`| json | app="other"`, `{"app": "other"}`, true},
+
+		// Identifiers and strings beyond ASCII
+		{`| json | café="crème"`, `{"café": "crème"}`, true},
+		{`| json | 名前="値"`, `{"名前": "値"}`, true},
+		{`| json | 名前="値"`, `{"名前": "他"}`, false},
+		{`|= "ü"`, "grüße", true},
+	} {
+		t.Run(test.query+" on "+test.message, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched := query.Lines.Match(newQueryEvent(test.message)); matched != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{`{app="x",`, "expected label name, got end of query"},
+		{`{app="x"`, "expected ',' or '}'"},
+		{`{app}`, "operator"},
+		{`{app=x}`, "quoted label value"},
+		{`{app="x" tier="y"}`, "expected ',' or '}'"},
+		{`{app=~"("}`, "invalid regexp"},
+		{`|= timeout`, "quoted string"},
+		{`|= "timeout`, "unterminated string"},
+		{`| json | status >`, "expected value"},
+		{`| json | status > "high"`, "requires a number"},
+		{`| 5`, "parser or label filter"},
+		{`|~ "("`, "invalid regexp"},
+		{`"timeout"`, "expected line filter"},
+		{`|= "a" @`, `unexpected '@'`},
+		{`|= "a" ©`, `unexpected '©' at position 7`},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			_, err := ParseQuery(test.query)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected error containing %q, got %q", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestTokenizeQueryDecodesRunes(t *testing.T) {
+	tokens, err := tokenizeQuery(`café>=-1.5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []queryToken{
+		{kind: queryIdentifier, value: "café"},
+		{kind: queryOperator, value: ">="},
+		{kind: queryNumber, value: "-1.5"},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("token %d: expected %v, got %v", i, expected[i], tokens[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+)
+
+// defaultRedactionReplacement replaces matches of a redaction that doesn't
+// specify a replacement.
+const defaultRedactionReplacement = "[REDACTED]"
+
+// Redaction replaces the parts of messages that match a pattern, such as
+// tokens or personal data. The replacement may refer to submatches of the
+// pattern, like $1, which allows transforming rather than just hiding text.
+type Redaction struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// redactor applies redactions to events. It counts what each redaction
+// altered, but never records the content it altered, so the counts can be
+// shared with whoever audits the redactions.
+type redactor struct {
+	redactions []Redaction
+	patterns   []*regexp.Regexp
+	stats      []redactionStats
+
+	// dryRun counts what would be altered without altering anything.
+	dryRun bool
+
+	sync.Mutex
+}
+
+type redactionStats struct {
+	lines      int64
+	matches    int64
+	containers map[string]bool
+}
+
+func newRedactor(redactions []Redaction, dryRun bool) (*redactor, error) {
+	r := &redactor{
+		redactions: make([]Redaction, len(redactions)),
+		patterns:   make([]*regexp.Regexp, len(redactions)),
+		stats:      make([]redactionStats, len(redactions)),
+		dryRun:     dryRun,
+	}
+	for i, redaction := range redactions {
+		if redaction.Pattern == "" {
+			return nil, fmt.Errorf("redaction #%d: pattern is required", i+1)
+		}
+		pattern, err := regexp.Compile(redaction.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction #%d: %w", i+1, err)
+		}
+		if redaction.Name == "" {
+			redaction.Name = "#" + strconv.Itoa(i+1)
+		}
+		if redaction.Replacement == "" {
+			redaction.Replacement = defaultRedactionReplacement
+		}
+		r.redactions[i] = redaction
+		r.patterns[i] = pattern
+		r.stats[i].containers = map[string]bool{}
+	}
+	return r, nil
+}
+
+// apply redacts an event's message in place.
+func (r *redactor) apply(event *LogEvent) {
+	for i, pattern := range r.patterns {
+		matches := pattern.FindAllStringIndex(event.Message, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		r.Lock()
+		stats := &r.stats[i]
+		stats.lines++
+		stats.matches += int64(len(matches))
+		stats.containers[buildContainerRef(event.Pod, event.Container)] = true
+		r.Unlock()
+
+		if !r.dryRun {
+			event.Message = pattern.ReplaceAllString(event.Message, r.redactions[i].Replacement)
+		}
+	}
+}
+
+// redact is an event middleware that applies the redactions.
+func (r *redactor) redact(event LogEvent) (LogEvent, bool) {
+	r.apply(&event)
+	return event, true
+}
+
+// report writes a summary of what each redaction altered.
+func (r *redactor) report(w io.Writer) error {
+	r.Lock()
+	defer r.Unlock()
+
+	verb := "ALTERED"
+	if r.dryRun {
+		verb = "WOULD ALTER"
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "REDACTION\t%s LINES\tMATCHES\tCONTAINERS\n", verb)
+	for i, redaction := range r.redactions {
+		stats := r.stats[i]
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", redaction.Name, stats.lines, stats.matches,
+			len(stats.containers))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// router sends events to the file of the first route that matches their
+// container.
+type router struct {
+	routes []*fileRoute
+}
+
+type fileRoute struct {
+	selector    containerSelector
+	file        *os.File
+	printEvent  func(*LogEvent) error
+	printMarker func(t time.Time, note string) error
+	sync.Mutex
+}
+
+func newRouter(routes []Route, metadata podMetadata) (*router, error) {
+	r := &router{}
+	for i, config := range routes {
+		route, err := newFileRoute(config, metadata)
+		if err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("route #%d: %w", i+1, err)
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r, nil
+}
+
+func newFileRoute(config Route, metadata podMetadata) (*fileRoute, error) {
+	if config.File == "" {
+		return nil, fmt.Errorf("file is required")
+	}
+	selector, err := newContainerSelector(config.Namespace, config.Pod, config.Container)
+	if err != nil {
+		return nil, err
+	}
+	route := &fileRoute{selector: selector}
+
+	format := config.Output
+	if format == "" {
+		format = "text"
+	}
+	if err := validateOutputFormat(format); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	route.file = f
+
+	switch format {
+	case "json":
+		route.printEvent = newJSONPrinter(f, metadata)
+	case "logfmt":
+		route.printEvent = newLogfmtPrinter(f, metadata)
+	case "proto":
+		route.printEvent = newProtoPrinter(f, metadata)
+	case "csv", "tsv":
+		separator := ','
+		if format == "tsv" {
+			separator = '\t'
+		}
+		// The file is appended to, so it only needs a header if it's empty
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		if route.printEvent, err = newCSVPrinter(f, nil, separator, info.Size() == 0); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	default:
+		route.printEvent = newPlainPrinter(f)
+		format = "plain"
+	}
+	route.printMarker = newMarkerPrinter(f, format)
+	return route, nil
+}
+
+// containerSelector selects containers in the config file. The namespace
+// must match the whole namespace name, while the pod and container match like
+// patterns given on the command line. Empty patterns match everything.
+type containerSelector struct {
+	namespace *regexp.Regexp
+	pod       *regexp.Regexp
+	container *regexp.Regexp
+}
+
+func newContainerSelector(namespace, pod, container string) (containerSelector, error) {
+	var selector containerSelector
+	var err error
+	if namespace != "" {
+		if selector.namespace, err = regexp.Compile("^(?:" + namespace + ")$"); err != nil {
+			return selector, fmt.Errorf("invalid namespace: %w", err)
+		}
+	}
+	if pod != "" {
+		if selector.pod, err = regexp.Compile(pod); err != nil {
+			return selector, fmt.Errorf("invalid pod: %w", err)
+		}
+	}
+	if container != "" {
+		if selector.container, err = regexp.Compile(container); err != nil {
+			return selector, fmt.Errorf("invalid container: %w", err)
+		}
+	}
+	return selector, nil
+}
+
+func (s containerSelector) matches(pod *v1.Pod, container *v1.Container) bool {
+	return (s.namespace == nil || s.namespace.MatchString(pod.Namespace)) &&
+		(s.pod == nil || s.pod.MatchString(pod.Name)) &&
+		(s.container == nil || s.container.MatchString(container.Name))
+}
+
+// route writes an event to the first matching route, returning false if no
+// route matches.
+func (r *router) route(event *LogEvent) (bool, error) {
+	for _, route := range r.routes {
+		if route.selector.matches(event.Pod, event.Container) {
+			route.Lock()
+			defer route.Unlock()
+			return true, route.printEvent(event)
+		}
+	}
+	return false, nil
+}
+
+// mark writes a bookmark to every route's file that can represent one.
+func (r *router) mark(t time.Time, note string) error {
+	for _, route := range r.routes {
+		if route.printMarker == nil {
+			continue
+		}
+		route.Lock()
+		err := route.printMarker(t, note)
+		route.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *router) Close() error {
+	var firstErr error
+	for _, route := range r.routes {
+		if err := route.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
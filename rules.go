@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Rule is an alerting rule. Whenever a log line matches the rule's pattern,
+// the rule's action is carried out.
+type Rule struct {
+	Name      string `yaml:"name"`
+	Pattern   string `yaml:"pattern"`
+	Container string `yaml:"container"`
+	Severity  string `yaml:"severity"`
+
+	// Action is one of "print" (the default), "notify", "exec", or "exit".
+	Action   string `yaml:"action"`
+	Command  string `yaml:"command"`
+	ExitCode int    `yaml:"exitCode"`
+
+	// Cooldown is the minimum time between carrying out the rule's action
+	// again for the same container.
+	Cooldown metav1.Duration `yaml:"cooldown"`
+
+	pattern   *regexp.Regexp
+	container *regexp.Regexp
+
+	// running is set while the rule's command runs; skipped counts triggers
+	// dropped meanwhile.
+	running atomic.Bool
+	skipped atomic.Int64
+}
+
+// defaultExecCooldown is the cooldown of exec rules that don't set one, so
+// that a chatty container doesn't start a command for every line.
+const defaultExecCooldown = 10 * time.Second
+
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules"`
+
+	// OnExit is called with the exit status when a rule's exit action is
+	// carried out. It's up to the caller to stop and exit.
+	OnExit func(code int) `yaml:"-"`
+
+	lastFired map[string]time.Time
+	sync.Mutex
+}
+
+var ruleSeverities = []string{"info", "warning", "error", "critical"}
+
+// LoadRules reads a rules file and validates its rules.
+func LoadRules(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules RuleSet
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules file %q: %w", path, err)
+	}
+	for i, rule := range rules.Rules {
+		if err := rule.compile(); err != nil {
+			name := rule.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return nil, fmt.Errorf("invalid rule %s in %q: %w", name, path, err)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Pattern
+		}
+	}
+	rules.lastFired = map[string]time.Time{}
+	return &rules, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.pattern = pattern
+
+	if r.Container != "" {
+		container, err := regexp.Compile(r.Container)
+		if err != nil {
+			return err
+		}
+		r.container = container
+	}
+
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if !slices.Contains(ruleSeverities, r.Severity) {
+		return fmt.Errorf("unknown severity %q", r.Severity)
+	}
+
+	switch r.Action {
+	case "":
+		r.Action = "print"
+	case "print", "notify":
+	case "exec":
+		if r.Command == "" {
+			return fmt.Errorf("exec action requires a command")
+		}
+		if r.Cooldown.Duration <= 0 {
+			r.Cooldown.Duration = defaultExecCooldown
+		}
+	case "exit":
+		if r.ExitCode == 0 {
+			r.ExitCode = 1
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+func (r *Rule) matches(event *LogEvent) bool {
+	if r.container != nil && !r.container.MatchString(event.Container.Name) {
+		return false
+	}
+	return r.pattern.MatchString(event.Message)
+}
+
+// Evaluate checks an event against all rules, and carries out the action of
+// each matching rule that is not cooling down.
+func (rs *RuleSet) Evaluate(event *LogEvent) {
+	for _, rule := range rs.Rules {
+		if rule.matches(event) && rs.shouldFire(rule, event) {
+			rule.fire(event)
+			if rule.Action == "exit" && rs.OnExit != nil {
+				rs.OnExit(rule.ExitCode)
+			}
+		}
+	}
+}
+
+func (rs *RuleSet) shouldFire(rule *Rule, event *LogEvent) bool {
+	if rule.Cooldown.Duration <= 0 {
+		return true
+	}
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	key := rule.Name + "\x00" + buildKey(event.Pod, event.Container)
+	now := time.Now()
+	if last, ok := rs.lastFired[key]; ok && now.Sub(last) < rule.Cooldown.Duration {
+		return false
+	}
+	rs.lastFired[key] = now
+	return true
+}
+
+func (r *Rule) fire(event *LogEvent) {
+	alert := fmt.Sprintf("[%s] Rule %q matched [%s/%s:%s]: %s", r.Severity, r.Name,
+		event.Pod.Namespace, event.Pod.Name, event.Container.Name, event.Message)
+
+	switch r.Action {
+	case "print":
+		r.print(alert)
+	case "notify":
+		// Ring the terminal bell to get the user's attention
+		_, _ = fmt.Fprint(os.Stderr, "\a")
+		r.print(alert)
+	case "exec":
+		// A rule runs one command at a time, so that commands can't pile up
+		// when they're slower than the rule fires
+		if !r.running.CompareAndSwap(false, true) {
+			r.skipped.Add(1)
+			return
+		}
+		go func() {
+			defer r.running.Store(false)
+			r.exec(event)
+			if skipped := r.skipped.Swap(0); skipped > 0 {
+				printError("Rule %q matched %d more times while its command was running", r.Name, skipped)
+			}
+		}()
+	case "exit":
+		r.print(alert)
+	}
+}
+
+func (r *Rule) print(alert string) {
+	if r.Severity == "info" {
+		printInfo("%s", alert)
+	} else {
+		printError("%s", alert)
+	}
+}
+
+// exec runs the rule's command with the system shell, passing details about the event
+// in the environment.
+func (r *Rule) exec(event *LogEvent) {
+	cmd := shellCommand(r.Command)
+	cmd.Env = append(os.Environ(),
+		"KTAIL_RULE="+r.Name,
+		"KTAIL_SEVERITY="+r.Severity,
+		"KTAIL_NAMESPACE="+event.Pod.Namespace,
+		"KTAIL_POD="+event.Pod.Name,
+		"KTAIL_CONTAINER="+event.Container.Name,
+		"KTAIL_TIMESTAMP="+event.Timestamp.Format(time.RFC3339Nano),
+		"KTAIL_MESSAGE="+event.Message,
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		printError("Command for rule %q failed: %s", r.Name, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExecRuleDefaultsCooldown(t *testing.T) {
+	rule := &Rule{Pattern: "x", Action: "exec", Command: "true"}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Cooldown.Duration != defaultExecCooldown {
+		t.Errorf("expected cooldown %s, got %s", defaultExecCooldown, rule.Cooldown.Duration)
+	}
+}
+
+func TestExecRuleRunsOneCommandAtATime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	rule := &Rule{
+		Pattern: "boom",
+		Action:  "exec",
+		Command: "echo $KTAIL_POD >> " + out + "; sleep 0.2",
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	// Without a cooldown, only the running command holds triggers back
+	rule.Cooldown.Duration = -1
+
+	timestamp := time.Now()
+	for i := 0; i < 20; i++ {
+		rule.fire(&LogEvent{
+			Pod:       &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "p"}},
+			Container: &v1.Container{Name: "c"},
+			Timestamp: &timestamp,
+			Message:   "boom",
+		})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rule.running.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "p\n"); lines != 1 {
+		t.Errorf("expected the command to run once, ran %d times", lines)
+	}
+	if skipped := rule.skipped.Load(); skipped != 0 {
+		t.Errorf("expected skipped triggers to be reported and reset, got %d", skipped)
+	}
+}
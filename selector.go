@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ParseSelectorExpression parses a boolean expression of label selectors,
+// such as:
+//
+//	(app=api OR app=worker) AND NOT tier=canary
+//
+// into a matcher of the pods it selects. AND, OR and NOT are case-insensitive,
+// and bind in the order NOT, AND, OR. Each term is a label selector without
+// spaces, like "app=api", "tier!=canary" or "app".
+func ParseSelectorExpression(s string) (Matcher, error) {
+	p := &selectorParser{tokens: tokenizeSelectorExpression(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	matcher, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return matcher, nil
+}
+
+func tokenizeSelectorExpression(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t\n\r()", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, s[i:end])
+			i = end
+		}
+	}
+	return tokens
+}
+
+type selectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *selectorParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// peekKeyword returns whether the next token is a keyword.
+func (p *selectorParser) peekKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *selectorParser) parseOr() (Matcher, error) {
+	var ors or
+	for {
+		matcher, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		ors = append(ors, matcher)
+		if !p.peekKeyword("or") {
+			break
+		}
+		p.pos++
+	}
+	if len(ors) == 1 {
+		return ors[0], nil
+	}
+	return ors, nil
+}
+
+func (p *selectorParser) parseAnd() (Matcher, error) {
+	var ands and
+	for {
+		matcher, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		ands = append(ands, matcher)
+		if !p.peekKeyword("and") {
+			break
+		}
+		p.pos++
+	}
+	if len(ands) == 1 {
+		return ands[0], nil
+	}
+	return ands, nil
+}
+
+func (p *selectorParser) parseUnary() (Matcher, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case strings.EqualFold(token, "not"):
+		p.pos++
+		matcher, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{matcher}, nil
+	case token == "(":
+		p.pos++
+		matcher, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("expected ')'")
+		}
+		p.pos++
+		return matcher, nil
+	case token == ")", strings.EqualFold(token, "and"), strings.EqualFold(token, "or"):
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	p.pos++
+	selector, err := labels.Parse(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", token, err)
+	}
+	return labelSelectorMatcher{selector}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSelectorExpression(t *testing.T) {
+	pods := map[string]*v1.Pod{}
+	for name, labels := range map[string]map[string]string{
+		"api":        {"app": "api", "tier": "stable"},
+		"api-canary": {"app": "api", "tier": "canary"},
+		"worker":     {"app": "worker"},
+		"web":        {"app": "web", "tier": "stable"},
+	} {
+		pods[name] = &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	for _, test := range []struct {
+		expression string
+		expected   []string
+	}{
+		{"app=api", []string{"api", "api-canary"}},
+		{"app!=api", []string{"web", "worker"}},
+		{"tier", []string{"api", "api-canary", "web"}},
+		{"!tier", []string{"worker"}},
+		{"app in (api,web)", nil}, // Terms can't contain spaces
+		{"app=api OR app=worker", []string{"api", "api-canary", "worker"}},
+		{"app=api AND tier=canary", []string{"api-canary"}},
+		{"NOT app=api", []string{"web", "worker"}},
+		{"(app=api OR app=worker) AND NOT tier=canary", []string{"api", "worker"}},
+		{"app=api or app=worker and tier=stable", []string{"api", "api-canary"}},
+		{"(app=api or app=worker) and tier=stable", []string{"api"}},
+		{"not not app=web", []string{"web"}},
+		{"not (tier=stable or tier=canary)", []string{"worker"}},
+		{"app=api,tier=stable", []string{"api"}},
+		{"  app=web\t", []string{"web"}},
+	} {
+		t.Run(test.expression, func(t *testing.T) {
+			matcher, err := ParseSelectorExpression(test.expression)
+			if test.expected == nil {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var matched []string
+			for _, name := range []string{"api", "api-canary", "web", "worker"} {
+				if matcher.Match(pods[name]) {
+					matched = append(matched, name)
+				}
+			}
+			if strings.Join(matched, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestParseSelectorExpressionErrors(t *testing.T) {
+	for _, test := range []struct {
+		expression string
+		expected   string
+	}{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{"app=api AND", "unexpected end of expression"},
+		{"OR app=api", `unexpected "OR"`},
+		{"(app=api", "expected ')'"},
+		{"app=api)", `unexpected ")"`},
+		{"()", `unexpected ")"`},
+		{"app=api app=web", `unexpected "app=web"`},
+		{"app==>api", "invalid selector"},
+	} {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := ParseSelectorExpression(test.expression)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected error containing %q, got %q", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// sessionExcludedFlags are not saved in session files, since they concern
+// how ktail is invoked rather than what it tails.
+var sessionExcludedFlags = map[string]bool{
+	"version":      true,
+	"bench":        true,
+	"session":      true,
+	"save-session": true,
+	"kubeconfig":   true,
+}
+
+// Session is the effective configuration of a run of ktail, which can be
+// saved and loaded again to reproduce the same tail.
+type Session struct {
+	Patterns []string               `json:"patterns,omitempty"`
+	Flags    map[string]interface{} `json:"flags"`
+}
+
+// SaveSession writes the effective value of every flag, along with the
+// patterns, to a file.
+func SaveSession(path string, flags *pflag.FlagSet, patterns []string) error {
+	session := Session{
+		Patterns: patterns,
+		Flags:    map[string]interface{}{},
+	}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden || sessionExcludedFlags[flag.Name] {
+			return
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			session.Flags[flag.Name] = slice.GetSlice()
+		} else {
+			session.Flags[flag.Name] = flag.Value.String()
+		}
+	})
+
+	data, err := sigsyaml.Marshal(&session)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing session file: %w", err)
+	}
+	return nil
+}
+
+// LoadSession applies the flags in a session file, except those that were
+// given on the command line, and returns the session's patterns.
+func LoadSession(path string, flags *pflag.FlagSet) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := yaml.UnmarshalStrict(data, &session); err != nil {
+		return nil, fmt.Errorf("parsing session file %q: %w", path, err)
+	}
+
+	names := make([]string, 0, len(session.Flags))
+	for name := range session.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil || sessionExcludedFlags[name] {
+			return nil, fmt.Errorf("session file %q: unknown flag %q", path, name)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setFlagFromSession(flag, session.Flags[name]); err != nil {
+			return nil, fmt.Errorf("session file %q: invalid value for flag %q: %w", path, name, err)
+		}
+		flag.Changed = true
+	}
+	return session.Patterns, nil
+}
+
+func setFlagFromSession(flag *pflag.Flag, value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		slice, ok := flag.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("expected a single value")
+		}
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		return slice.Replace(values)
+	case nil:
+		return nil
+	default:
+		return flag.Value.Set(fmt.Sprint(v))
+	}
+}
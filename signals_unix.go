@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// markSignals are the signals that insert a bookmark into the output.
+var markSignals = []os.Signal{syscall.SIGUSR1}
+
+// shutdownSignals are the signals that stop tailing.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
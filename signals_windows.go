@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// markSignals are the signals that insert a bookmark into the output. Windows
+// has no user-defined signals.
+var markSignals []os.Signal
+
+// shutdownSignals are the signals that stop tailing. Go delivers both Ctrl+C
+// and Ctrl+Break as os.Interrupt, and closing the console window, logging off
+// or shutting down as SIGTERM.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -5,17 +5,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jpillora/backoff"
+	"golang.org/x/text/encoding"
+	"golang.org/x/time/rate"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
 type tailState int
@@ -26,33 +32,173 @@ const (
 )
 
 type LogEvent struct {
+	Pod        *v1.Pod
+	Container  *v1.Container
+	Timestamp  *time.Time
+	Message    string
+	LineNumber int64
+
+	// MatchLabel is the label of the --match selector that selected the pod,
+	// if any.
+	MatchLabel string
+
+	// Parser is how fields are extracted from the message (see eventFields).
+	Parser string
+
+	// Marker is set for a bookmark inserted by the user rather than a log
+	// line, in which case the message is the bookmark's note, and there is no
+	// pod or container. Only the output buffer carries markers.
+	Marker bool
+}
+
+type LogEventFunc func(LogEvent)
+
+type TailerOptions struct {
+	// Encoding is the character encoding of the container's output. If nil,
+	// output is assumed to be UTF-8.
+	Encoding encoding.Encoding
+
+	// KeepControlChars disables normalization of carriage returns and control
+	// characters in messages.
+	KeepControlChars bool
+
+	// ThroughputWarning is the rate in bytes per second above which
+	// OnHighThroughput is called. Zero disables the check.
+	ThroughputWarning int64
+
+	// RequestTimeout is how long to wait for a log stream to be opened. Zero
+	// means no timeout.
+	RequestTimeout time.Duration
+
+	// KubeletFallback enables reading logs through the node's kubelet (using
+	// the API server's node proxy) when requesting them through the API
+	// server's log endpoint keeps failing.
+	KubeletFallback bool
+
+	// Backfill, if set, is where history older than the kubelet's retention
+	// is fetched from before tailing.
+	Backfill BackfillSource
+
+	// StallTimeout is how long a stream can be silent before checking whether
+	// the container has in fact logged anything since, in which case the
+	// stream is re-established. Zero disables stall detection.
+	StallTimeout time.Duration
+
+	// TailLines, if set, limits how many lines of history are read when the
+	// stream is first opened.
+	TailLines *int64
+
+	// RateLimit is the number of lines per second above which lines are
+	// dropped, and OnRateLimited is called. Zero means no limit.
+	RateLimit float64
+
+	// RetryMin and RetryMax bound the interval between attempts to
+	// re-establish a stream after an error. Zero means the default.
+	RetryMin time.Duration
+	RetryMax time.Duration
+
+	// Parser is how fields are extracted from messages: "json" (the
+	// default), "logfmt", or "none".
+	Parser string
+}
+
+// StreamStats describes how a tailer is keeping up with its container.
+type StreamStats struct {
 	Pod       *v1.Pod
 	Container *v1.Container
-	Timestamp *time.Time
-	Message   string
+	BytesRead int64
+
+	// LastTimestamp is the timestamp of the latest line, or zero until a line
+	// has been received.
+	LastTimestamp time.Time
 }
 
-type LogEventFunc func(LogEvent)
+type TailerCallbacks struct {
+	OnError           func(err error)
+	OnHighThroughput  func(bytesPerSecond float64)
+	OnWaiting         func(reason string)
+	OnUnauthorized    func()
+	OnKubeletFallback func(err error)
+	OnRateLimited     func(dropped int64)
+
+	// CurrentPod returns the latest known version of the pod, if set.
+	CurrentPod func() *v1.Pod
+}
+
+// minStallTimeout is the shortest stall timeout. Every check for a stall
+// requests the container's log, so checks can't be frequent.
+const minStallTimeout = time.Second
+
+var errStreamStalled = fmt.Errorf("log stream stalled while container kept logging; reconnecting")
+
+const (
+	// waitingRetryMin and waitingRetryMax bound the interval between attempts
+	// to open the log stream of a container that hasn't started yet.
+	waitingRetryMin = 500 * time.Millisecond
+	waitingRetryMax = 10 * time.Second
+
+	// kubeletFallbackThreshold is the number of consecutive failures to get
+	// a log stream from the API server before falling back to the kubelet.
+	kubeletFallbackThreshold = 3
+
+	// maxUnauthorizedRetries is how many times to retry opening a stream with
+	// refreshed credentials before giving up.
+	maxUnauthorizedRetries = 3
+
+	// probeBytes is how much log to read when checking for a stall, or for
+	// the earliest line the kubelet has.
+	probeBytes = 4096
+
+	// throughputWindow is the interval over which throughput is measured.
+	throughputWindow = 5 * time.Second
+
+	// throughputWarningInterval is the minimum time between warnings about
+	// the same stream.
+	throughputWarningInterval = time.Minute
+
+	// rateLimitReportInterval is the minimum time between reports of lines
+	// dropped by the rate limit of the same stream.
+	rateLimitReportInterval = 10 * time.Second
+
+	// drainTimeout limits how long reading the final lines of a terminated
+	// container may take.
+	drainTimeout = 10 * time.Second
+)
 
 func NewContainerTailer(
 	client kubernetes.Interface,
 	pod v1.Pod,
 	container v1.Container,
 	eventFunc LogEventFunc,
-	fromTimestamp *time.Time) *ContainerTailer {
+	fromTimestamp *time.Time,
+	options TailerOptions) *ContainerTailer {
+	var decoder *encoding.Decoder
+	if options.Encoding != nil {
+		decoder = options.Encoding.NewDecoder()
+	}
+	var limiter *rate.Limiter
+	if options.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(options.RateLimit), max(1, int(options.RateLimit)))
+	}
 	return &ContainerTailer{
+		TailerOptions: options,
 		client:        client,
 		pod:           pod,
 		container:     container,
 		eventFunc:     eventFunc,
 		fromTimestamp: fromTimestamp,
-		errorBackoff:  &backoff.Backoff{},
+		errorBackoff:  &backoff.Backoff{Min: options.RetryMin, Max: options.RetryMax},
 		state:         tailStateNormal,
+		decoder:       decoder,
+		limiter:       limiter,
+		done:          make(chan struct{}),
 	}
 }
 
 type ContainerTailer struct {
+	TailerOptions
 	client           kubernetes.Interface
+	clientLock       sync.Mutex
 	pod              v1.Pod
 	container        v1.Container
 	stop             atomic.Bool
@@ -61,37 +207,205 @@ type ContainerTailer struct {
 	errorBackoff     *backoff.Backoff
 	lastLineChecksum []byte
 	state            tailState
+	lineNumber       int64
+	decoder          *encoding.Decoder
+	callbacks        TailerCallbacks
+	windowStart      time.Time
+	windowBytes      int64
+	lastWarning      time.Time
+	lastLineAt       atomic.Int64
+	lastTimestamp    atomic.Int64
+	bytesRead        atomic.Int64
+	limiter          *rate.Limiter
+	dropped          int64
+	lastDropReport   time.Time
+	done             chan struct{}
+}
+
+// SetClient replaces the client used for subsequent requests.
+func (ct *ContainerTailer) SetClient(client kubernetes.Interface) {
+	ct.clientLock.Lock()
+	defer ct.clientLock.Unlock()
+	ct.client = client
+}
+
+func (ct *ContainerTailer) getClient() kubernetes.Interface {
+	ct.clientLock.Lock()
+	defer ct.clientLock.Unlock()
+	return ct.client
+}
+
+// Stats returns how much the tailer has read, and how recent its latest line is.
+func (ct *ContainerTailer) Stats() StreamStats {
+	stats := StreamStats{
+		Pod:       &ct.pod,
+		Container: &ct.container,
+		BytesRead: ct.bytesRead.Load(),
+	}
+	if t := ct.lastTimestamp.Load(); t != 0 {
+		stats.LastTimestamp = time.Unix(0, t)
+	}
+	return stats
 }
 
 func (ct *ContainerTailer) Stop() {
 	ct.stop.Store(true)
 }
 
-func (ct *ContainerTailer) Run(ctx context.Context, onError func(err error)) {
+// Drain waits for a stopped tailer to finish, and then reads the lines that
+// the container logged after the last line the stream delivered, which are
+// otherwise lost when the container is killed or its pod is evicted. With
+// previous, the lines are read from the previous run of a restarted container.
+func (ct *ContainerTailer) Drain(previous bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	select {
+	case <-ct.done:
+	case <-ctx.Done():
+		return
+	}
+	if ct.fromTimestamp == nil {
+		// Nothing was read, and the history may have been limited
+		return
+	}
+	if err := ct.fetchWindow(ctx, *ct.fromTimestamp, time.Now(), previous); err != nil {
+		ct.callbacks.OnError(fmt.Errorf("reading final lines: %w", err))
+	}
+	ct.reportDropped(true)
+}
+
+func (ct *ContainerTailer) Run(ctx context.Context, callbacks TailerCallbacks) {
+	defer close(ct.done)
+	defer ct.reportDropped(true)
+	ct.callbacks = callbacks
 	ct.errorBackoff.Reset()
+	if ct.Backfill != nil {
+		ct.backfill(ctx)
+	}
+	failures := 0
 	for !ct.stop.Load() {
-		stream, err := ct.getStream(ctx)
+		streamCtx, cancel := context.WithCancel(ctx)
+		stream, err := ct.getStream(streamCtx)
 		if err != nil {
+			failures++
+			if ct.KubeletFallback && failures >= kubeletFallbackThreshold {
+				// The next attempt will go through the API server again
+				failures = 0
+				// The kubelet only has logs of pods that are running on its node
+				if pod := ct.currentPod(); pod.Status.Phase == v1.PodRunning && pod.Spec.NodeName != "" {
+					if kubeletStream, kubeletErr := ct.getKubeletStream(streamCtx, pod); kubeletErr == nil {
+						if ct.callbacks.OnKubeletFallback != nil {
+							ct.callbacks.OnKubeletFallback(err)
+						}
+						stream, err = kubeletStream, nil
+					}
+				}
+			}
+		} else {
+			failures = 0
+		}
+		if err != nil {
+			cancel()
 			time.Sleep(ct.errorBackoff.Duration())
-			onError(err)
+			ct.callbacks.OnError(err)
 			continue
 		}
 		if stream == nil {
+			cancel()
 			break
 		}
-		if err := ct.runStream(stream); err != nil {
-			onError(err)
+		err = ct.runStream(streamCtx, stream, cancel)
+		cancel()
+		if err != nil {
+			ct.callbacks.OnError(err)
 			time.Sleep(ct.errorBackoff.Duration())
 		}
 		ct.state = tailStateRecover
 	}
 }
 
-func (ct *ContainerTailer) runStream(stream io.ReadCloser) error {
+// backfill emits the history that the kubelet may no longer have from the
+// backfill source, up to the present. Tailing then carries on from the last
+// line that was backfilled.
+func (ct *ContainerTailer) backfill(ctx context.Context) {
+	from := ct.fromTimestamp
+	if from == nil {
+		from = containerStartTime(&ct.pod, &ct.container)
+	}
+	if from == nil || time.Since(*from) < backfillMinAge {
+		return
+	}
+	// Anything from the kubelet's earliest line on is read from the kubelet
+	to := time.Now()
+	if first, ok := ct.firstLogTimestamp(ctx, false); ok && first.Before(to) {
+		to = first
+	}
+	if !to.After(*from) {
+		return
+	}
+	err := ct.Backfill.Fetch(ctx, &ct.pod, &ct.container, *from, to, func(t time.Time, message string) {
+		ct.bytesRead.Add(int64(len(message)))
+		ct.emitLine(t, message)
+	})
+	if err != nil {
+		ct.callbacks.OnError(fmt.Errorf("backfilling history: %w", err))
+	}
+}
+
+// fetchWindow reads the lines logged from the given time by the current run
+// of the container, or by the previous one, stopping once a line is logged
+// at or after to.
+func (ct *ContainerTailer) fetchWindow(ctx context.Context, from, to time.Time, previous bool) error {
+	stream, err := ct.openStream(ctx, &v1.PodLogOptions{
+		Container:  ct.container.Name,
+		Timestamps: true,
+		SinceTime:  &metav1.Time{Time: from.UTC()},
+		Previous:   previous,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	r := bufio.NewReader(stream)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ct.receiveLine(line)
+		if t := ct.lastTimestamp.Load(); t != 0 && !time.Unix(0, t).Before(to) {
+			return nil
+		}
+	}
+}
+
+func (ct *ContainerTailer) runStream(ctx context.Context, stream io.ReadCloser, cancel func()) error {
 	defer func() {
 		_ = stream.Close()
 	}()
 
+	var stalled atomic.Bool
+	if ct.StallTimeout > 0 {
+		since := time.Now()
+		if ct.fromTimestamp != nil {
+			since = *ct.fromTimestamp
+		}
+		ct.lastLineAt.Store(time.Now().UnixNano())
+		done := make(chan struct{})
+		defer close(done)
+		go ct.watchForStall(ctx, since, done, func() {
+			stalled.Store(true)
+			cancel()
+		})
+	}
+
 	r := bufio.NewReader(stream)
 	for {
 		line, err := r.ReadString('\n')
@@ -99,15 +413,142 @@ func (ct *ContainerTailer) runStream(stream io.ReadCloser) error {
 			break
 		}
 		if err != nil {
+			if stalled.Load() {
+				return errStreamStalled
+			}
 			return err
 		}
 		ct.errorBackoff.Reset()
+		ct.lastLineAt.Store(time.Now().UnixNano())
+		ct.trackThroughput(len(line))
 		ct.receiveLine(line)
 	}
+	if stalled.Load() {
+		return errStreamStalled
+	}
 	return nil
 }
 
+// watchForStall calls onStall if the stream has been silent for longer than
+// the stall timeout while the container has logged newer lines, which happens
+// when the kubelet's log proxy stops forwarding a stream.
+func (ct *ContainerTailer) watchForStall(
+	ctx context.Context,
+	since time.Time,
+	done <-chan struct{},
+	onStall func()) {
+	ticker := time.NewTicker(ct.StallTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, ct.lastLineAt.Load())) < ct.StallTimeout {
+			continue
+		}
+		if t := ct.lastTimestamp.Load(); t != 0 {
+			since = time.Unix(0, t)
+		}
+		if ct.hasLogsAfter(ctx, since) {
+			onStall()
+			return
+		}
+		// Don't check again until the stream has been silent for another period
+		ct.lastLineAt.Store(time.Now().UnixNano())
+	}
+}
+
+// hasLogsAfter checks, using a separate request, whether the container has
+// logged a line later than the given time.
+func (ct *ContainerTailer) hasLogsAfter(ctx context.Context, t time.Time) bool {
+	if ct.RequestTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, ct.RequestTimeout)
+		defer cancel()
+	}
+
+	limitBytes := int64(probeBytes)
+	data, err := ct.getClient().CoreV1().Pods(ct.pod.Namespace).GetLogs(ct.pod.Name, &v1.PodLogOptions{
+		Container:  ct.container.Name,
+		Timestamps: true,
+		SinceTime:  &metav1.Time{Time: t.UTC()},
+		LimitBytes: &limitBytes,
+	}).DoRaw(ctx)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		timeString, _, _ := strings.Cut(line, " ")
+		if timestamp, err := time.Parse(time.RFC3339Nano, timeString); err == nil && timestamp.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLogTimestamp returns the timestamp of the earliest line that the
+// kubelet still has of the current run of the container, or of the previous
+// one.
+func (ct *ContainerTailer) firstLogTimestamp(ctx context.Context, previous bool) (time.Time, bool) {
+	if ct.RequestTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, ct.RequestTimeout)
+		defer cancel()
+	}
+
+	limitBytes := int64(probeBytes)
+	data, err := ct.getClient().CoreV1().Pods(ct.pod.Namespace).GetLogs(ct.pod.Name, &v1.PodLogOptions{
+		Container:  ct.container.Name,
+		Timestamps: true,
+		LimitBytes: &limitBytes,
+		Previous:   previous,
+	}).DoRaw(ctx)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		timeString, _, _ := strings.Cut(line, " ")
+		if timestamp, err := time.Parse(time.RFC3339Nano, timeString); err == nil {
+			return timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (ct *ContainerTailer) trackThroughput(n int) {
+	if ct.ThroughputWarning <= 0 || ct.callbacks.OnHighThroughput == nil {
+		return
+	}
+
+	now := time.Now()
+	if ct.windowStart.IsZero() {
+		ct.windowStart = now
+	}
+	ct.windowBytes += int64(n)
+
+	elapsed := now.Sub(ct.windowStart)
+	if elapsed < throughputWindow {
+		return
+	}
+	rate := float64(ct.windowBytes) / elapsed.Seconds()
+	ct.windowStart, ct.windowBytes = now, 0
+	if rate > float64(ct.ThroughputWarning) && now.Sub(ct.lastWarning) >= throughputWarningInterval {
+		ct.lastWarning = now
+		ct.callbacks.OnHighThroughput(rate)
+	}
+}
+
 func (ct *ContainerTailer) receiveLine(s string) {
+	ct.bytesRead.Add(int64(len(s)))
+
+	if ct.decoder != nil {
+		if decoded, err := ct.decoder.String(s); err == nil {
+			s = decoded
+		}
+	}
 	if len(s) > 0 && s[len(s)-1] == '\n' {
 		s = s[0 : len(s)-1]
 	}
@@ -131,6 +572,14 @@ func (ct *ContainerTailer) receiveLine(s string) {
 		return
 	}
 
+	ct.emitLine(timestamp, message)
+}
+
+func (ct *ContainerTailer) emitLine(timestamp time.Time, message string) {
+	if !ct.KeepControlChars {
+		message = normalizeControlChars(message)
+	}
+
 	checksum := checksumLine(message)
 
 	if ct.state == tailStateRecover {
@@ -151,15 +600,45 @@ func (ct *ContainerTailer) receiveLine(s string) {
 	// On restart, start from this timestamp. This isn't exact, however.
 	nextTimestamp := timestamp.Add(time.Millisecond * 1)
 	ct.fromTimestamp = &nextTimestamp
+	ct.lastTimestamp.Store(timestamp.UnixNano())
+
+	ct.lineNumber++
+
+	if ct.limiter != nil && !ct.limiter.Allow() {
+		ct.dropped++
+		ct.reportDropped(false)
+		return
+	}
+	// The end of a burst is reported once it's due
+	ct.reportDropped(false)
 
 	ct.eventFunc(LogEvent{
-		Pod:       &ct.pod,
-		Container: &ct.container,
-		Timestamp: &timestamp,
-		Message:   parts[1],
+		Pod:        &ct.pod,
+		Container:  &ct.container,
+		Timestamp:  &timestamp,
+		Message:    message,
+		LineNumber: ct.lineNumber,
+		Parser:     ct.Parser,
 	})
 }
 
+// reportDropped reports how many lines the rate limit has dropped since the
+// last report, if any. Unless final, reports are at most
+// rateLimitReportInterval apart.
+func (ct *ContainerTailer) reportDropped(final bool) {
+	if ct.dropped == 0 {
+		return
+	}
+	now := time.Now()
+	if !final && now.Sub(ct.lastDropReport) < rateLimitReportInterval {
+		return
+	}
+	if ct.callbacks.OnRateLimited != nil {
+		ct.callbacks.OnRateLimited(ct.dropped)
+	}
+	ct.dropped, ct.lastDropReport = 0, now
+}
+
 func (ct *ContainerTailer) getStream(ctx context.Context) (io.ReadCloser, error) {
 	var sinceTime *metav1.Time
 	if ct.fromTimestamp != nil {
@@ -168,14 +647,17 @@ func (ct *ContainerTailer) getStream(ctx context.Context) (io.ReadCloser, error)
 		}
 	}
 
-	boff := &backoff.Backoff{}
-	for {
-		stream, err := ct.client.CoreV1().Pods(ct.pod.Namespace).GetLogs(ct.pod.Name, &v1.PodLogOptions{
+	boff := &backoff.Backoff{Min: waitingRetryMin, Max: waitingRetryMax}
+	notifiedWaiting := false
+	unauthorizedRetries := 0
+	for !ct.stop.Load() {
+		stream, err := ct.openStream(ctx, &v1.PodLogOptions{
 			Container:  ct.container.Name,
 			Follow:     true,
 			Timestamps: true,
 			SinceTime:  sinceTime,
-		}).Stream(ctx)
+			TailLines:  ct.tailLines(),
+		})
 		if err == nil {
 			return stream, nil
 		}
@@ -183,14 +665,186 @@ func (ct *ContainerTailer) getStream(ctx context.Context) (io.ReadCloser, error)
 			// This will happen if the pod isn't ready for log-reading yet
 			switch status.Status().Code {
 			case http.StatusBadRequest:
+				if reason, ok := containerWaitingReason(status.Status().Message); ok && !notifiedWaiting {
+					notifiedWaiting = true
+					if ct.callbacks.OnWaiting != nil {
+						ct.callbacks.OnWaiting(reason)
+					}
+				}
 				time.Sleep(boff.Duration())
 				continue
 			case http.StatusNotFound:
 				return nil, nil
+			case http.StatusUnauthorized:
+				// Credentials have probably expired. Exec plugins refresh their
+				// credentials when a request is rejected, and the client may be
+				// rebuilt from the kubeconfig, so try again.
+				if unauthorizedRetries < maxUnauthorizedRetries {
+					unauthorizedRetries++
+					if ct.callbacks.OnUnauthorized != nil {
+						ct.callbacks.OnUnauthorized()
+					}
+					time.Sleep(boff.Duration())
+					continue
+				}
+				return nil, fmt.Errorf("credentials were rejected by the API server and could not be"+
+					" refreshed; they may have expired: %w", err)
 			}
 		}
 		return nil, err
 	}
+	return nil, nil
+}
+
+// containerWaitingReason extracts the reason from the error returned when
+// requesting logs for a container that hasn't started yet, such as
+// "ContainerCreating" or "PodInitializing".
+func containerWaitingReason(message string) (string, bool) {
+	if _, reason, ok := strings.Cut(message, "is waiting to start: "); ok {
+		return reason, true
+	}
+	if strings.Contains(message, "is waiting to start") {
+		return "waiting", true
+	}
+	return "", false
+}
+
+// currentPod returns the latest known version of the pod.
+func (ct *ContainerTailer) currentPod() *v1.Pod {
+	if ct.callbacks.CurrentPod != nil {
+		return ct.callbacks.CurrentPod()
+	}
+	return &ct.pod
+}
+
+// getKubeletStream opens a log stream by asking the kubelet of the pod's node
+// directly, through the API server's node proxy. This requires permission to
+// use nodes/proxy.
+func (ct *ContainerTailer) getKubeletStream(ctx context.Context, pod *v1.Pod) (io.ReadCloser, error) {
+	var sinceTime *metav1.Time
+	if ct.fromTimestamp != nil {
+		sinceTime = &metav1.Time{
+			Time: ct.fromTimestamp.UTC(),
+		}
+	}
+
+	request := ct.getClient().CoreV1().RESTClient().Get().
+		Resource("nodes").
+		Name(pod.Spec.NodeName).
+		SubResource("proxy").
+		Suffix("containerLogs", ct.pod.Namespace, ct.pod.Name, ct.container.Name).
+		VersionedParams(&v1.PodLogOptions{
+			Follow:     true,
+			Timestamps: true,
+			SinceTime:  sinceTime,
+			TailLines:  ct.tailLines(),
+		}, scheme.ParameterCodec)
+	return request.Stream(ctx)
+}
+
+// tailLines returns how many lines of history to read, which is only limited
+// until the first line has been received.
+func (ct *ContainerTailer) tailLines() *int64 {
+	if ct.lineNumber > 0 {
+		return nil
+	}
+	return ct.TailLines
+}
+
+// openStream requests a log stream, giving up if the stream can't be opened
+// within the request timeout.
+func (ct *ContainerTailer) openStream(ctx context.Context, options *v1.PodLogOptions) (io.ReadCloser, error) {
+	request := ct.getClient().CoreV1().Pods(ct.pod.Namespace).GetLogs(ct.pod.Name, options)
+	if ct.RequestTimeout <= 0 {
+		return request.Stream(ctx)
+	}
+
+	// The context can't simply have a deadline, since it also governs reading
+	// the stream, which can go on indefinitely.
+	requestCtx, cancel := context.WithCancel(ctx)
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(ct.RequestTimeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	stream, err := request.Stream(requestCtx)
+	if !timer.Stop() && timedOut.Load() {
+		if stream != nil {
+			_ = stream.Close()
+		}
+		return nil, fmt.Errorf("timed out after %s waiting for log stream", ct.RequestTimeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelingReadCloser{ReadCloser: stream, cancel: cancel}, nil
+}
+
+// cancelingReadCloser cancels a context when closed.
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (r *cancelingReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// normalizeControlChars makes a message safe to display. Carriage returns are
+// treated the way a terminal would, so for output like progress bars that
+// redraws a line, only the final text is kept. Other control characters are
+// removed, except tabs, and escapes that start a color sequence (ESC [ ... m);
+// any other escape sequence could move the cursor, retitle the window or
+// write to the clipboard, so its ESC is removed, leaving the rest as text.
+func normalizeControlChars(s string) string {
+	if i := strings.LastIndexByte(strings.TrimRight(s, "\r"), '\r'); i >= 0 {
+		s = s[i+1:]
+	}
+	if strings.IndexFunc(s, isDisallowedControlChar) < 0 && strings.IndexByte(s, 0x1b) < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == 0x1b {
+			if n := colorSequenceLength(s[i:]); n > 0 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+		} else if !isDisallowedControlChar(r) {
+			// Written as is, so that invalid UTF-8 is left alone
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// isDisallowedControlChar returns whether a character is a C0 or C1 control
+// character, other than tab. Escapes are handled separately.
+func isDisallowedControlChar(r rune) bool {
+	return (r < 0x20 || (r >= 0x7f && r <= 0x9f)) && r != '\t' && r != 0x1b
+}
+
+// colorSequenceLength returns the length of the SGR (color) sequence that s
+// starts with, or 0 if it doesn't start with one.
+func colorSequenceLength(s string) int {
+	if len(s) < 3 || s[0] != 0x1b || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 'm':
+			return i + 1
+		case (c < '0' || c > '9') && c != ';' && c != ':':
+			return 0
+		}
+	}
+	return 0
 }
 
 func checksumLine(s string) []byte {
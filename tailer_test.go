@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeControlChars(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"tab", "a\tb", "a\tb"},
+		{"progress", "10%\r50%\r100%", "100%"},
+		{"trailing carriage return", "done\r", "done"},
+		{"bell and backspace", "a\a\bb", "ab"},
+		{"delete and C1", "a\x7fb\u009bc", "abc"},
+		{"color", "\x1b[1;31merror\x1b[0m ok", "\x1b[1;31merror\x1b[0m ok"},
+		{"color reset", "\x1b[m", "\x1b[m"},
+		{"cursor movement", "\x1b[2Jcleared", "[2Jcleared"},
+		{"clipboard", "\x1b]52;c;ZXZpbA==\a", "]52;c;ZXZpbA=="},
+		{"title", "\x1b]0;owned\x1b\\", "]0;owned\\"},
+		{"lone escape", "a\x1b", "a"},
+		{"unterminated color", "\x1b[31", "[31"},
+		{"invalid UTF-8", "a\xffb\x01", "a\xffb"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := normalizeControlChars(test.message); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/mattn/go-colorable"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -13,3 +14,13 @@ func isTerminal(w io.Writer) bool {
 	}
 	return false
 }
+
+// consoleWriter returns a writer that renders colored output on the terminal
+// that f writes to. On Windows consoles that can't process ANSI escape
+// sequences, they are translated into console API calls instead.
+func consoleWriter(f *os.File) io.Writer {
+	if !isTerminal(f) || enableVirtualTerminal(f) {
+		return f
+	}
+	return colorable.NewColorable(f)
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableVirtualTerminal reports whether the terminal that f writes to
+// processes ANSI escape sequences, which Unix terminals always do.
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}